@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"syscall"
 
+	"github.com/feliixx/gotranseq/ncbicode"
 	"github.com/feliixx/gotranseq/transeq"
 	"github.com/jessevdk/go-flags"
 )
@@ -19,15 +26,103 @@ var Version string
 
 // GlobalOptions struct to store command line args
 type GlobalOptions struct {
-	Required        `group:"required"`
-	transeq.Options `group:"optional"`
-	General         `group:"general"`
+	Translate TranslateCommand `command:"translate" description:"Translate nucleic acid sequences (default command)"`
+	Infer     InferCommand     `command:"infer" description:"Rank the NCBI codes by consistency with the sequences"`
+	Validate  ValidateCommand  `command:"validate" description:"Check that each sequence is a complete CDS"`
+	ORF       ORFCommand       `command:"orf" description:"Find the open reading frames of the sequences"`
+	Tables    TablesCommand    `command:"tables" description:"List, print and compare the NCBI codes"`
+	General   `group:"general"`
 }
 
 // Required struct to store required command line args
 type Required struct {
-	Sequence string `short:"s" long:"sequence" value-name:"<filename>" description:"Nucleotide sequence(s) filename"`
-	Outseq   string `short:"o" long:"outseq" value-name:"<filename>" description:"Protein sequence filename"`
+	Sequence []string `short:"s" long:"sequence" value-name:"<filename>" description:"Nucleotide sequence(s) filename. For translate, can be repeated, and can be a glob pattern or a directory, in which case all its .fa, .fna, .fasta, .ffn and .fas files are translated"`
+	Outseq   string   `short:"o" long:"outseq" value-name:"<filename>" description:"Protein sequence filename"`
+}
+
+// TranslateCommand struct to store translate command line args
+type TranslateCommand struct {
+	Required        `group:"required"`
+	transeq.Options `group:"optional"`
+	Provenance      struct {
+		Manifest string `long:"manifest" value-name:"<filename>" description:"Write a JSON manifest of the run to <filename>: version of gotranseq, command line, effective options including the nb of workers, translation of each codon for the NCBI codes used, paths, sizes and sha256 checksums of the input and output files, and start and end times"`
+	} `group:"provenance"`
+	Batch struct {
+		OutputTemplate string `long:"output-template" value-name:"<template>" description:"Write the proteins of each input file to its own file, named after <template> where {name} is replaced by the name of the input file without its extension, and {dir} by its directory, for example {dir}/{name}.faa. Without a template, the proteins of several input files are written to --outseq, with 'source=<file>' in their header"`
+	} `group:"batch"`
+}
+
+// Usage of the translate command
+func (c *TranslateCommand) Usage() string {
+	return "--sequence file.fna --outseq out.faa"
+}
+
+// InferCommand struct to store genetic code inference command line args
+type InferCommand struct {
+	Required `group:"required"`
+	Infer    struct {
+		Frame       string           `short:"f" long:"frame" value-name:"<code>" description:"Frame(s) to consider, with the same values as for translate" default:"1"`
+		Alternative bool             `short:"a" long:"alternative" description:"Use the BLASTX and DIAMOND convention for reverse frames, see translate"`
+		BySequence  bool             `long:"by-sequence" description:"Rank the codes for each sequence instead of the whole file"`
+		NumWorker   int              `short:"n" long:"numcpu" value-name:"<n>" description:"Number of worker to use (default: number of CPU)"`
+		MaxMemory   transeq.ByteSize `long:"max-memory" value-name:"<size>" description:"Approximate memory budget, see translate (default: no limit)"`
+		Force       bool             `long:"force" description:"Overwrite the output file if it already exists"`
+	} `group:"optional"`
+}
+
+// Usage of the infer command
+func (c *InferCommand) Usage() string {
+	return "--sequence file.fna --outseq report.tsv"
+}
+
+// ValidateCommand struct to store CDS validation command line args
+type ValidateCommand struct {
+	Required `group:"required"`
+	Validate struct {
+		Table          transeq.TableCode `short:"t" long:"table" value-name:"<code>" description:"NCBI code to use, by id, name or alias" default:"0"`
+		TableMap       string            `long:"table-map" value-name:"<filename>" description:"Tab separated file giving the NCBI code to use for specific sequences, see translate"`
+		TableModifiers bool              `long:"table-modifiers" description:"Read the NCBI code to use from sequence headers, see translate"`
+		Selenocysteine bool              `long:"selenocysteine" description:"Don't report TGA as an internal stop codon"`
+		Pyrrolysine    bool              `long:"pyrrolysine" description:"Don't report TAG as an internal stop codon"`
+		NumWorker      int               `short:"n" long:"numcpu" value-name:"<n>" description:"Number of worker to use (default: number of CPU)"`
+		MaxMemory      transeq.ByteSize  `long:"max-memory" value-name:"<size>" description:"Approximate memory budget, see translate (default: no limit)"`
+		Force          bool              `long:"force" description:"Overwrite the output file if it already exists"`
+	} `group:"optional"`
+}
+
+// Usage of the validate command
+func (c *ValidateCommand) Usage() string {
+	return "--sequence cds.fna --outseq report.tsv"
+}
+
+// ORFCommand struct to store open reading frame command line args
+type ORFCommand struct {
+	Required `group:"required"`
+	ORF      struct {
+		Frame       string            `short:"f" long:"frame" value-name:"<code>" description:"Frame(s) to search, with the same values as for translate" default:"6"`
+		Alternative bool              `short:"a" long:"alternative" description:"Use the BLASTX and DIAMOND convention for reverse frames, see translate"`
+		Table       transeq.TableCode `short:"t" long:"table" value-name:"<code>" description:"NCBI code to use, by id, name or alias" default:"0"`
+		MinLength   int               `long:"min-length" value-name:"<n>" description:"Discard ORFs shorter than <n> residues" default:"30"`
+		NumWorker   int               `short:"n" long:"numcpu" value-name:"<n>" description:"Number of worker to use (default: number of CPU)"`
+		MaxMemory   transeq.ByteSize  `long:"max-memory" value-name:"<size>" description:"Approximate memory budget, see translate (default: no limit)"`
+		Force       bool              `long:"force" description:"Overwrite the output file if it already exists"`
+	} `group:"optional"`
+}
+
+// Usage of the orf command
+func (c *ORFCommand) Usage() string {
+	return "--sequence file.fna --outseq orfs.faa"
+}
+
+// TablesCommand struct to store NCBI code browser command line args
+type TablesCommand struct {
+	Show string `long:"show" value-name:"<code>" description:"Print the NCBI code as the 4x16 grid of codons used on the NCBI website. Start codons are marked with 'i'"`
+	Diff string `long:"diff" value-name:"<code>,<code>" description:"Print the codons translated differently by the two NCBI codes, or that are start codons in only one of them"`
+}
+
+// Usage of the tables command
+func (c *TablesCommand) Usage() string {
+	return "[--show <code> | --diff <code>,<code>]"
 }
 
 // General struct to store required command line args
@@ -36,40 +131,361 @@ type General struct {
 	Version bool `short:"v" long:"version" description:"Print the tool version and exit"`
 }
 
-func run(options GlobalOptions) error {
+// fastaExtensions are the extensions of the files translated when a
+// directory is given as input
+var fastaExtensions = map[string]bool{
+	".fa":    true,
+	".fna":   true,
+	".fasta": true,
+	".ffn":   true,
+	".fas":   true,
+}
+
+// expandInputs returns the input files of the --sequence values, where
+// glob patterns are replaced by the files they match, and directories by
+// their fasta files, sorted by name
+func expandInputs(values []string) ([]string, error) {
+
+	var inputs []string
+	for _, value := range values {
+
+		if strings.ContainsAny(value, "*?[") {
+			matches, err := filepath.Glob(value)
+			if err != nil {
+				return nil, fmt.Errorf("wrong value for --sequence parameter: %s, %v", value, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("wrong value for --sequence parameter: %s, no file matches the pattern", value)
+			}
+			inputs = append(inputs, matches...)
+			continue
+		}
+
+		info, err := os.Stat(value)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			inputs = append(inputs, value)
+			continue
+		}
+		files, err := ioutil.ReadDir(value)
+		if err != nil {
+			return nil, err
+		}
+		n := len(inputs)
+		for _, f := range files {
+			if f.Mode().IsRegular() && fastaExtensions[strings.ToLower(filepath.Ext(f.Name()))] {
+				inputs = append(inputs, filepath.Join(value, f.Name()))
+			}
+		}
+		if len(inputs) == n {
+			return nil, fmt.Errorf("wrong value for --sequence parameter: %s, no fasta file in the directory", value)
+		}
+	}
+	return inputs, nil
+}
+
+// openInput opens the input file, and returns the number of worker to
+// use
+func openInput(required Required, numWorker int) (in *os.File, n int, err error) {
+
+	if len(required.Sequence) == 0 {
+		return nil, 0, fmt.Errorf("missing required parameter -s | -sequence, try %s --help for details", toolName)
+	}
+	if len(required.Sequence) > 1 {
+		return nil, 0, fmt.Errorf("wrong value for --sequence parameter: only translate accepts several input files")
+	}
+	if required.Outseq == "" {
+		return nil, 0, fmt.Errorf("missing required parameter -o | -outseq, try %s --help for details", toolName)
+	}
+
+	if numWorker == 0 {
+		numWorker = runtime.NumCPU()
+	}
+
+	in, err = os.Open(required.Sequence[0])
+	if err != nil {
+		return nil, 0, err
+	}
+	return in, numWorker, nil
+}
+
+// openFiles opens the input and the output files, and returns the
+// number of worker to use. The output is only renamed to its final name
+// once committed
+func openFiles(required Required, numWorker int, force bool) (in *os.File, out *transeq.AtomicFile, n int, err error) {
 
-	if options.Sequence == "" {
+	in, numWorker, err = openInput(required, numWorker)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	out, err = transeq.CreateAtomic(required.Outseq, force)
+	if err != nil {
+		in.Close()
+		return nil, nil, 0, err
+	}
+	return in, out, numWorker, nil
+}
+
+func runTranslate(ctx context.Context, c TranslateCommand) error {
+
+	if len(c.Sequence) == 0 {
 		return fmt.Errorf("missing required parameter -s | -sequence, try %s --help for details", toolName)
 	}
-	if options.Outseq == "" {
-		return fmt.Errorf("missing required parameter -o | -outseq, try %s --help for details", toolName)
+	inputs, err := expandInputs(c.Sequence)
+	if err != nil {
+		return err
+	}
+	if len(inputs) > 1 || c.Batch.OutputTemplate != "" {
+		return runTranslateFiles(ctx, inputs, c)
+	}
+	c.Sequence = inputs
+	c.Stats = &transeq.Stats{}
+	defer reportPeakMemory(c.Stats, c.MaxMemory)
+
+	// output files are created by transeq.TranslateToFiles
+	in, numWorker, err := openInput(c.Required, c.NumWorker)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	c.NumWorker = numWorker
+	if c.Provenance.Manifest != "" {
+		return translateWithManifest(ctx, in, c)
+	}
+	_, err = transeq.TranslateToFiles(ctx, in, c.Outseq, c.Options)
+	return err
+}
+
+// runTranslateFiles translates several input files, or writes the output
+// of each input to its own file
+func runTranslateFiles(ctx context.Context, inputs []string, c TranslateCommand) error {
+
+	if c.Outseq == "" && c.Batch.OutputTemplate == "" {
+		return fmt.Errorf("missing required parameter -o | -outseq or --output-template, try %s --help for details", toolName)
+	}
+	if c.NumWorker == 0 {
+		c.NumWorker = runtime.NumCPU()
 	}
+	c.Stats = &transeq.Stats{}
+	defer reportPeakMemory(c.Stats, c.MaxMemory)
 
-	if options.NumWorker == 0 {
-		options.NumWorker = runtime.NumCPU()
+	if c.Provenance.Manifest != "" {
+		return translateFilesWithManifest(ctx, inputs, c)
 	}
+	_, err := transeq.TranslateFiles(ctx, inputs, c.Outseq, c.Batch.OutputTemplate, c.Options)
+	return err
+}
+
+func runInfer(ctx context.Context, c InferCommand) error {
 
-	in, err := os.Open(options.Sequence)
+	in, out, numWorker, err := openFiles(c.Required, c.Infer.NumWorker, c.Infer.Force)
 	if err != nil {
 		return err
 	}
 	defer in.Close()
 
-	out, err := os.Create(options.Outseq)
+	options := transeq.Options{
+		Frame:       c.Infer.Frame,
+		Alternative: c.Infer.Alternative,
+		NumWorker:   numWorker,
+		MaxMemory:   c.Infer.MaxMemory,
+		Stats:       &transeq.Stats{},
+	}
+	defer reportPeakMemory(options.Stats, options.MaxMemory)
+
+	err = transeq.InferTableContext(ctx, in, out, options, c.Infer.BySequence)
+	if err != nil {
+		out.Abort()
+		return err
+	}
+	return out.Commit()
+}
+
+func runValidate(ctx context.Context, c ValidateCommand) error {
+
+	in, out, numWorker, err := openFiles(c.Required, c.Validate.NumWorker, c.Validate.Force)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	options := transeq.Options{
+		Table:          c.Validate.Table,
+		TableMap:       c.Validate.TableMap,
+		TableModifiers: c.Validate.TableModifiers,
+		Selenocysteine: c.Validate.Selenocysteine,
+		Pyrrolysine:    c.Validate.Pyrrolysine,
+		NumWorker:      numWorker,
+		MaxMemory:      c.Validate.MaxMemory,
+		Stats:          &transeq.Stats{},
+	}
+	defer reportPeakMemory(options.Stats, options.MaxMemory)
+
+	invalid, err := transeq.ValidateContext(ctx, in, out, options)
+	if err != nil {
+		out.Abort()
+		return err
+	}
+	err = out.Commit()
+	if err != nil {
+		return err
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d sequence(s) failed validation, see %s for details", invalid, c.Outseq)
+	}
+	return nil
+}
+
+// runORF writes each stretch starting with a methionine and ending before
+// a stop codon as a separate record, named as with translate --split
+func runORF(ctx context.Context, c ORFCommand) error {
+
+	in, numWorker, err := openInput(c.Required, c.ORF.NumWorker)
 	if err != nil {
 		return err
 	}
-	defer out.Close()
+	defer in.Close()
+
+	options := transeq.Options{
+		Frame:       c.ORF.Frame,
+		Alternative: c.ORF.Alternative,
+		Table:       c.ORF.Table,
+		Split:       true,
+		ORF:         true,
+		MinFragment: c.ORF.MinLength,
+		NumWorker:   numWorker,
+		MaxMemory:   c.ORF.MaxMemory,
+		Stats:       &transeq.Stats{},
+		Force:       c.ORF.Force,
+	}
+	defer reportPeakMemory(options.Stats, options.MaxMemory)
+
+	_, err = transeq.TranslateToFiles(ctx, in, c.Outseq, options)
+	return err
+}
+
+// reportPeakMemory prints the peak memory used by a run with a memory
+// budget
+func reportPeakMemory(stats *transeq.Stats, maxMemory transeq.ByteSize) {
+	if maxMemory != 0 {
+		fmt.Fprintf(os.Stderr, "peak memory: %v (max memory: %v)\n", transeq.ByteSize(stats.PeakMemory), maxMemory)
+	}
+}
 
-	return transeq.Translate(in, out, options.Options)
+func runTables(c TablesCommand) error {
+
+	switch {
+	case c.Show != "" && c.Diff != "":
+		return fmt.Errorf("--show and --diff can't be used together")
+	case c.Show != "":
+		t, err := ncbicode.LookupTable(c.Show)
+		if err != nil {
+			return err
+		}
+		return transeq.WriteTableGrid(os.Stdout, t.ID)
+	case c.Diff != "":
+		codes := strings.Split(c.Diff, ",")
+		if len(codes) != 2 {
+			return fmt.Errorf("wrong value for --diff parameter: %s, expected two comma separated codes", c.Diff)
+		}
+		t1, err := ncbicode.LookupTable(codes[0])
+		if err != nil {
+			return err
+		}
+		t2, err := ncbicode.LookupTable(codes[1])
+		if err != nil {
+			return err
+		}
+		return transeq.WriteTableDiff(os.Stdout, t1.ID, t2.ID)
+	}
+	return transeq.WriteTableList(os.Stdout)
+}
+
+func run(ctx context.Context, p *flags.Parser, options GlobalOptions) error {
+
+	switch p.Active.Name {
+	case "infer":
+		return runInfer(ctx, options.Infer)
+	case "validate":
+		return runValidate(ctx, options.Validate)
+	case "orf":
+		return runORF(ctx, options.ORF)
+	case "tables":
+		return runTables(options.Tables)
+	}
+	return runTranslate(ctx, options.Translate)
+}
+
+// cancelOnSignal returns a context cancelled on the first SIGINT or
+// SIGTERM. A second signal terminates the process as usual
+func cancelOnSignal() context.Context {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		cancel()
+	}()
+	return ctx
+}
+
+// addTableList appends the list of the available NCBI codes, generated
+// from the ncbicode package, to the description of the --table flag of
+// each command
+func addTableList(p *flags.Parser) error {
+
+	list := ". Available codes:"
+	for _, code := range ncbicode.TableCodes() {
+		t, err := ncbicode.GetTable(code)
+		if err != nil {
+			return err
+		}
+		list += fmt.Sprintf("\n %d (%s): %s", t.ID, strings.Join(t.Aliases, ", "), t.Name)
+	}
+	list += fmt.Sprintf("\nThe standard code can also be selected with %d, as on the NCBI website\n", ncbicode.StandardNCBI)
+
+	for _, c := range p.Commands() {
+		if opt := c.FindOptionByLongName("table"); opt != nil {
+			opt.Description += list
+		}
+	}
+	return nil
+}
+
+// withDefaultCommand returns the command line arguments, with the
+// translate command added if no command is given, so gotranseq can be
+// used as before the introduction of commands
+func withDefaultCommand(p *flags.Parser, args []string) []string {
+	if len(args) > 0 {
+		switch args[0] {
+		case "-h", "--help", "-v", "--version":
+			return args
+		}
+		if p.Find(args[0]) != nil {
+			return args
+		}
+	}
+	return append([]string{"translate"}, args...)
 }
 
 func main() {
 
 	var options GlobalOptions
 	p := flags.NewParser(&options, flags.Default&^flags.HelpFlag)
-	p.Usage = "--sequence file.fna --outseq out.faa"
-	_, err := p.Parse()
+	p.Usage = "[options]"
+	p.SubcommandsOptional = true
+	err := addTableList(p)
+	if err != nil {
+		fmt.Printf("fail to load NCBI codes: %v\n", err)
+		os.Exit(1)
+	}
+	_, err = p.ParseArgs(withDefaultCommand(p, os.Args[1:]))
 	if err != nil {
 		fmt.Printf("wrong arguments: %v, try %s --help for more informations\n", err, toolName)
 		os.Exit(1)
@@ -77,6 +493,13 @@ func main() {
 	if options.Help {
 		fmt.Printf("%s %s\n\n", toolName, Version)
 		p.WriteHelp(os.Stdout)
+		if p.Active == nil {
+			// translate is the default command, so its options are
+			// documented with the general ones
+			p.Active = p.Find("translate")
+			fmt.Println()
+			p.WriteHelp(os.Stdout)
+		}
 		os.Exit(0)
 	}
 	if options.Version {
@@ -84,8 +507,16 @@ func main() {
 		os.Exit(0)
 	}
 
-	err = run(options)
+	ctx := cancelOnSignal()
+	err = run(ctx, p, options)
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("interrupted, incomplete output files have been removed")
+		if p.Active.Name == "translate" && options.Translate.Resume {
+			err = fmt.Errorf("interrupted, run the same command again to resume the run")
+		}
+	}
 	if err != nil {
-		fmt.Printf("fail to translate file:\n%v", err)
+		fmt.Printf("fail to run %s %s:\n%v\n", toolName, p.Active.Name, err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/feliixx/gotranseq/transeq"
+)
+
+// manifest describes how a protein file was produced
+type manifest struct {
+	Tool    string   `json:"tool"`
+	Version string   `json:"version"`
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	// effective options, including the resolved nb of workers
+	Options transeq.Options `json:"options"`
+	// amino acid of each codon, for each NCBI code that may be used
+	Tables   map[int]map[string]string `json:"tables"`
+	Inputs   []manifestFile            `json:"inputs"`
+	Outputs  []manifestFile            `json:"outputs"`
+	Started  time.Time                 `json:"started"`
+	Finished time.Time                 `json:"finished"`
+}
+
+type manifestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func newManifestFile(f *os.File, h hash.Hash) (manifestFile, error) {
+
+	path, err := filepath.Abs(f.Name())
+	if err != nil {
+		return manifestFile{}, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return manifestFile{}, err
+	}
+	return manifestFile{
+		Path:   path,
+		Size:   info.Size(),
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// hashFile reads a file written during the run to compute its checksum
+func hashFile(name string) (manifestFile, error) {
+
+	f, err := os.Open(name)
+	if err != nil {
+		return manifestFile{}, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return manifestFile{}, err
+	}
+	return newManifestFile(f, h)
+}
+
+// hashedInput computes the checksum of the input while it's read
+type hashedInput struct {
+	f *os.File
+	h hash.Hash
+}
+
+func (r hashedInput) Read(p []byte) (int, error) {
+	n, err := r.f.Read(p)
+	r.h.Write(p[:n])
+	return n, err
+}
+
+// Stat gives access to the size of the input, used to report the
+// progress of the run
+func (r hashedInput) Stat() (os.FileInfo, error) {
+	return r.f.Stat()
+}
+
+// translateWithManifest translates the input, and writes the manifest of
+// the run to c.Provenance.Manifest
+func translateWithManifest(ctx context.Context, in *os.File, c TranslateCommand) error {
+
+	m, err := newManifest(c)
+	if err != nil {
+		return err
+	}
+
+	inHash := sha256.New()
+	files, err := transeq.TranslateToFiles(ctx, hashedInput{f: in, h: inHash}, c.Outseq, c.Options)
+	if err != nil {
+		return err
+	}
+	m.Finished = time.Now().UTC()
+
+	input, err := newManifestFile(in, inHash)
+	if err != nil {
+		return err
+	}
+	m.Inputs = []manifestFile{input}
+
+	// output files are only renamed once the run is complete, so
+	// they're read again to compute their checksum
+	for _, name := range files {
+		file, err := hashFile(name)
+		if err != nil {
+			return err
+		}
+		m.Outputs = append(m.Outputs, file)
+	}
+	return m.write(c.Provenance.Manifest)
+}
+
+// translateFilesWithManifest translates several input files, and writes
+// the manifest of the run to c.Provenance.Manifest
+func translateFilesWithManifest(ctx context.Context, inputs []string, c TranslateCommand) error {
+
+	m, err := newManifest(c)
+	if err != nil {
+		return err
+	}
+	files, err := transeq.TranslateFiles(ctx, inputs, c.Outseq, c.Batch.OutputTemplate, c.Options)
+	if err != nil {
+		return err
+	}
+	m.Finished = time.Now().UTC()
+
+	// inputs are read by several workers at once, so they're read
+	// again like the outputs to compute their checksum
+	for _, name := range inputs {
+		file, err := hashFile(name)
+		if err != nil {
+			return err
+		}
+		m.Inputs = append(m.Inputs, file)
+	}
+	for _, name := range files {
+		file, err := hashFile(name)
+		if err != nil {
+			return err
+		}
+		m.Outputs = append(m.Outputs, file)
+	}
+	return m.write(c.Provenance.Manifest)
+}
+
+// newManifest returns the manifest of a translate run starting now
+func newManifest(c TranslateCommand) (*manifest, error) {
+
+	tables, err := transeq.TableContents(c.Options)
+	if err != nil {
+		return nil, err
+	}
+	return &manifest{
+		Tool:    toolName,
+		Version: Version,
+		Command: "translate",
+		Args:    os.Args[1:],
+		Options: c.Options,
+		Tables:  tables,
+		Started: time.Now().UTC(),
+	}, nil
+}
+
+// write writes the manifest to the file name
+func (m *manifest) write(name string) error {
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -9,6 +9,8 @@ package ncbicode
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -340,6 +342,7 @@ var (
 // details.
 const (
 	Standard                                                    = 0
+	StandardNCBI                                                = 1 // NCBI numbers the standard code 1, both values are accepted
 	VertebrateMitochondrial                                     = 2
 	YeastMitochondrial                                          = 3
 	MoldProtozoanCoelenterateMitochondrialMycoplasmaSpiroplasma = 4
@@ -362,6 +365,129 @@ const (
 	Peritrich                                                   = 30
 )
 
+// Table holds the metadata of a NCBI code
+type Table struct {
+	ID int
+	// name of the code, as on the NCBI website
+	Name string
+	// short names that can be used instead of the id, in lower case
+	Aliases []string
+	// start codons, including alternative start codons
+	StartCodons []string
+	// stop codons, in alphabetical order
+	StopCodons []string
+}
+
+// metadata of each NCBI code. Stop codons are computed from the
+// codon <-> AA map
+var tables = map[int]Table{
+	Standard: {
+		Name:        "Standard Code",
+		Aliases:     []string{"standard"},
+		StartCodons: []string{"TTG", "CTG", "ATG"},
+	},
+	VertebrateMitochondrial: {
+		Name:        "The Vertebrate Mitochondrial Code",
+		Aliases:     []string{"vertebrate-mito"},
+		StartCodons: []string{"ATT", "ATC", "ATA", "ATG", "GTG"},
+	},
+	YeastMitochondrial: {
+		Name:        "The Yeast Mitochondrial Code",
+		Aliases:     []string{"yeast-mito"},
+		StartCodons: []string{"ATA", "ATG", "GTG"},
+	},
+	MoldProtozoanCoelenterateMitochondrialMycoplasmaSpiroplasma: {
+		Name:        "The Mold, Protozoan, and Coelenterate Mitochondrial Code and the Mycoplasma/Spiroplasma Code",
+		Aliases:     []string{"mold-mito", "mycoplasma", "spiroplasma"},
+		StartCodons: []string{"TTA", "TTG", "CTG", "ATT", "ATC", "ATA", "ATG", "GTG"},
+	},
+	InvertebrateMitochondrial: {
+		Name:        "The Invertebrate Mitochondrial Code",
+		Aliases:     []string{"invertebrate-mito"},
+		StartCodons: []string{"TTG", "ATT", "ATC", "ATA", "ATG", "GTG"},
+	},
+	CiliateDasycladaceanHexamita: {
+		Name:        "The Ciliate, Dasycladacean and Hexamita Nuclear Code",
+		Aliases:     []string{"ciliate", "dasycladacean", "hexamita"},
+		StartCodons: []string{"ATG"},
+	},
+	EchinodermFlatwormMitochondrial: {
+		Name:        "The Echinoderm and Flatworm Mitochondrial Code",
+		Aliases:     []string{"echinoderm-mito", "flatworm-mito"},
+		StartCodons: []string{"ATG", "GTG"},
+	},
+	Euplotid: {
+		Name:        "The Euplotid Nuclear Code",
+		Aliases:     []string{"euplotid"},
+		StartCodons: []string{"ATG"},
+	},
+	BacterialArchaealPlantPlastid: {
+		Name:        "The Bacterial, Archaeal and Plant Plastid Code",
+		Aliases:     []string{"bacterial", "archaeal", "plastid"},
+		StartCodons: []string{"TTG", "CTG", "ATT", "ATC", "ATA", "ATG", "GTG"},
+	},
+	AlternativeYeast: {
+		Name:        "The Alternative Yeast Nuclear Code",
+		Aliases:     []string{"alt-yeast"},
+		StartCodons: []string{"CTG", "ATG"},
+	},
+	AscidianMitochondrial: {
+		Name:        "The Ascidian Mitochondrial Code",
+		Aliases:     []string{"ascidian-mito"},
+		StartCodons: []string{"TTG", "ATA", "ATG", "GTG"},
+	},
+	AlternativeFlatwormMitochondrial: {
+		Name:        "The Alternative Flatworm Mitochondrial Code",
+		Aliases:     []string{"alt-flatworm-mito"},
+		StartCodons: []string{"ATG"},
+	},
+	ChlorophyceanMitochondrial: {
+		Name:        "Chlorophycean Mitochondrial Code",
+		Aliases:     []string{"chlorophycean-mito"},
+		StartCodons: []string{"ATG"},
+	},
+	TrematodeMitochondrial: {
+		Name:        "Trematode Mitochondrial Code",
+		Aliases:     []string{"trematode-mito"},
+		StartCodons: []string{"ATG", "GTG"},
+	},
+	ScenedesmusObliquusMitochondrial: {
+		Name:        "Scenedesmus obliquus Mitochondrial Code",
+		Aliases:     []string{"scenedesmus-mito"},
+		StartCodons: []string{"ATG"},
+	},
+	ThraustochytriumMitochondrial: {
+		Name:        "Thraustochytrium Mitochondrial Code",
+		Aliases:     []string{"thraustochytrium-mito"},
+		StartCodons: []string{"ATT", "ATG", "GTG"},
+	},
+	PterobranchiaMitochondrial: {
+		Name:        "Pterobranchia Mitochondrial Code",
+		Aliases:     []string{"pterobranchia-mito"},
+		StartCodons: []string{"TTG", "CTG", "ATG", "GTG"},
+	},
+	CandidateDivisionSR1Gracilibacteria: {
+		Name:        "Candidate Division SR1 and Gracilibacteria Code",
+		Aliases:     []string{"sr1", "gracilibacteria"},
+		StartCodons: []string{"TTG", "ATG", "GTG"},
+	},
+	PachysolenTannophilus: {
+		Name:        "Pachysolen tannophilus Nuclear Code",
+		Aliases:     []string{"pachysolen"},
+		StartCodons: []string{"CTG", "ATG"},
+	},
+	Mesodinium: {
+		Name:        "Mesodinium Nuclear Code",
+		Aliases:     []string{"mesodinium"},
+		StartCodons: []string{"ATG"},
+	},
+	Peritrich: {
+		Name:        "Peritrich Nuclear Code",
+		Aliases:     []string{"peritrich"},
+		StartCodons: []string{"ATG"},
+	},
+}
+
 // LoadTableCode returns a map of condon <-> AA generated from
 // the provided NCBI code
 func LoadTableCode(code int) (map[string]byte, error) {
@@ -371,7 +497,7 @@ func LoadTableCode(code int) (map[string]byte, error) {
 		tableCodon[codon] = aaCode
 	}
 
-	if code != Standard {
+	if code != Standard && code != StandardNCBI {
 
 		tableDiff, ok := diffs[code]
 		if !ok {
@@ -386,3 +512,63 @@ func LoadTableCode(code int) (map[string]byte, error) {
 	}
 	return tableCodon, nil
 }
+
+// TableCodes returns the list of available NCBI codes, in
+// ascending order
+func TableCodes() []int {
+	codes := []int{Standard}
+	for code := range diffs {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	return codes
+}
+
+// GetTable returns the metadata of the provided NCBI code
+func GetTable(code int) (Table, error) {
+
+	if code == StandardNCBI {
+		code = Standard
+	}
+	t, ok := tables[code]
+	if !ok {
+		return Table{}, fmt.Errorf("invalid table code: %v", code)
+	}
+
+	codeMap, err := LoadTableCode(code)
+	if err != nil {
+		return Table{}, err
+	}
+	t.ID = code
+	t.Aliases = append([]string(nil), t.Aliases...)
+	t.StartCodons = append([]string(nil), t.StartCodons...)
+	for codon, aaCode := range codeMap {
+		if aaCode == '*' {
+			t.StopCodons = append(t.StopCodons, codon)
+		}
+	}
+	sort.Strings(t.StopCodons)
+	return t, nil
+}
+
+// LookupTable returns the metadata of the NCBI code matching value,
+// which can be the id of the code, its name or one of its aliases. Names
+// and aliases are case insensitive
+func LookupTable(value string) (Table, error) {
+
+	value = strings.TrimSpace(value)
+	if code, err := strconv.Atoi(value); err == nil {
+		return GetTable(code)
+	}
+	for code, t := range tables {
+		if strings.EqualFold(value, t.Name) {
+			return GetTable(code)
+		}
+		for _, alias := range t.Aliases {
+			if strings.EqualFold(value, alias) {
+				return GetTable(code)
+			}
+		}
+	}
+	return Table{}, fmt.Errorf("invalid table code: %v", value)
+}
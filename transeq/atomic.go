@@ -0,0 +1,71 @@
+package transeq
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// AtomicFile is an output file written to a temporary file in the same
+// directory, and renamed once complete, so readers never see a partial
+// output
+type AtomicFile struct {
+	*os.File
+	name string
+}
+
+// CreateAtomic creates the temporary file of the output file name. It
+// fails if name already exists, unless force is set
+func CreateAtomic(name string, force bool) (*AtomicFile, error) {
+	f, err := createTemp(name, force)
+	if err != nil {
+		return nil, err
+	}
+	return &AtomicFile{File: f, name: name}, nil
+}
+
+// Name returns the name of the output file, not of the temporary file
+func (f *AtomicFile) Name() string {
+	return f.name
+}
+
+// Commit closes the temporary file and renames it to the output file
+func (f *AtomicFile) Commit() error {
+	err := f.File.Close()
+	if err != nil {
+		os.Remove(f.File.Name())
+		return err
+	}
+	return os.Rename(f.File.Name(), f.name)
+}
+
+// Abort closes and removes the temporary file, leaving the output file
+// untouched
+func (f *AtomicFile) Abort() error {
+	f.File.Close()
+	return os.Remove(f.File.Name())
+}
+
+// createTemp creates a hidden temporary file next to the output file
+// name. Like os.Create, it creates the file with mode 0666 before umask,
+// so the output file has the usual permissions once renamed
+func createTemp(name string, force bool) (*os.File, error) {
+
+	if !force {
+		if _, err := os.Stat(name); err == nil {
+			return nil, fmt.Errorf("output file %s already exists, use --force to overwrite it", name)
+		}
+	}
+	prefix := filepath.Join(filepath.Dir(name), "."+filepath.Base(name)+".tmp")
+	for i := 0; i < 10000; i++ {
+		suffix := strconv.FormatInt(time.Now().UnixNano()+int64(i), 36)
+		f, err := os.OpenFile(prefix+suffix, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) {
+			continue
+		}
+		return f, err
+	}
+	return nil, fmt.Errorf("fail to create a temporary file for %s", name)
+}
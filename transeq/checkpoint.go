@@ -0,0 +1,289 @@
+package transeq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// interval between two saves of the checkpoint of a resumable run
+const checkpointInterval = time.Second
+
+// checkpoint records the progress of a resumable run, so it can be
+// continued after a failure or an interruption
+type checkpoint struct {
+	// options of the run that change its output
+	Options Options `json:"options"`
+	// size of the input, or -1 if unknown
+	InputSize int64 `json:"input_size"`
+	// offset in the input of the end of the last record written, and
+	// size of the output at this point
+	InputOffset int64 `json:"input_offset"`
+	OutputSize  int64 `json:"output_size"`
+
+	name  string
+	saved time.Time
+}
+
+// outputOptions returns the options that change the output of a run
+func outputOptions(o Options) Options {
+	o = withDefaults(o)
+	o.NumWorker = 0
+	o.MaxMemory = 0
+	o.Progress = false
+	o.Summary = false
+	o.SummaryJSON = ""
+	o.Force = false
+	o.Resume = false
+	o.Stats = nil
+	return o
+}
+
+// loadCheckpoint reads the checkpoint name, and returns nil if it doesn't
+// exist
+func loadCheckpoint(name string) (*checkpoint, error) {
+
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &checkpoint{name: name}
+	err = json.Unmarshal(data, c)
+	if err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %s: %v", name, err)
+	}
+	return c, nil
+}
+
+// check returns an error if the run can't be resumed with these options
+// and an input of this size
+func (c *checkpoint) check(options Options, inputSize int64) error {
+
+	var changed []string
+	previous, current := reflect.ValueOf(c.Options), reflect.ValueOf(outputOptions(options))
+	for i := 0; i < previous.NumField(); i++ {
+		if !reflect.DeepEqual(previous.Field(i).Interface(), current.Field(i).Interface()) {
+			changed = append(changed, "--"+previous.Type().Field(i).Tag.Get("long"))
+		}
+	}
+	if len(changed) > 0 {
+		return fmt.Errorf("can't resume the run saved in %s, the value of %s differs from the original run", c.name, strings.Join(changed, ", "))
+	}
+	if c.InputSize >= 0 && inputSize >= 0 && c.InputSize != inputSize {
+		return fmt.Errorf("can't resume the run saved in %s, the input has %d bytes instead of %d", c.name, inputSize, c.InputSize)
+	}
+	return nil
+}
+
+// save writes the checkpoint to a temporary file renamed once complete,
+// so the previous checkpoint stays valid if the run is killed meanwhile.
+// The output is synced first, so that the checkpoint never refers to
+// data lost on a crash of the system
+func (c *checkpoint) save(out io.Writer) error {
+
+	if f, ok := out.(*os.File); ok {
+		err := f.Sync()
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := createTemp(c.name, true)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	c.saved = time.Now()
+	return os.Rename(f.Name(), c.name)
+}
+
+// chunkOutput is the translation of a chunk of a resumable run
+type chunkOutput struct {
+	index int64
+	end   int64
+	buf   []byte
+}
+
+// writeOrdered writes the translations of the chunks of src to its
+// output in input order, and regularly saves the progress of the run to
+// its checkpoint. The slot of each chunk in src.window is released once
+// the chunk is written. It returns once results is closed
+func writeOrdered(results <-chan chunkOutput, src *source) {
+
+	out, c := src.out, src.checkpoint
+	start := c.InputOffset
+	pending := map[int64]chunkOutput{}
+	var next int64
+	failed, saved := false, true
+
+	for r := range results {
+
+		pending[r.index] = r
+		for {
+			r, ok := pending[next]
+			if !ok || failed {
+				break
+			}
+			delete(pending, next)
+			next++
+			<-src.window
+
+			n, err := out.w.Write(r.buf)
+			if err != nil {
+				// the records partially written are removed when
+				// the run is resumed
+				out.fail(fmt.Errorf("fail to write to output file: %v", err))
+				failed = true
+				break
+			}
+			c.InputOffset = start + r.end
+			c.OutputSize += int64(n)
+			saved = false
+
+			if time.Since(c.saved) >= checkpointInterval {
+				err = c.save(out.w)
+				if err != nil {
+					out.fail(fmt.Errorf("fail to save checkpoint: %v", err))
+					failed = true
+				}
+				saved = err == nil
+			}
+		}
+	}
+	if !saved {
+		err := c.save(out.w)
+		if err != nil {
+			out.fail(fmt.Errorf("fail to save checkpoint: %v", err))
+		}
+	}
+}
+
+// translateResumable translates the sequences of inputSequence to outseq,
+// in input order. The output is written to outseq.partial, and the
+// progress of the run is saved to outseq.checkpoint, so that a run that
+// failed or was interrupted can be resumed from the last record written.
+// If a checkpoint exists, the run is resumed, provided the options and
+// the size of the input didn't change
+func translateResumable(ctx context.Context, inputSequence io.Reader, outseq string, options Options) ([]string, error) {
+
+	if options.SplitsOutput() {
+		return nil, fmt.Errorf("--resume can't be used with --by-frame, --by-record, --shard-records or --shard-size")
+	}
+	name, partial := outseq+".checkpoint", outseq+".partial"
+
+	c, err := loadCheckpoint(name)
+	if err != nil {
+		return nil, err
+	}
+	size := inputSize(inputSequence)
+
+	var out *os.File
+	if c == nil {
+		if _, err := os.Stat(outseq); err == nil && !options.Force {
+			return nil, fmt.Errorf("output file %s already exists, use --force to overwrite it", outseq)
+		}
+		c = &checkpoint{
+			Options:   outputOptions(options),
+			InputSize: size,
+			name:      name,
+		}
+		out, err = os.Create(partial)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		err = c.check(options, size)
+		if err != nil {
+			return nil, err
+		}
+		out, err = c.resumeOutput(partial)
+		if err != nil {
+			return nil, err
+		}
+		err = skipInput(inputSequence, c.InputOffset)
+		if err != nil {
+			out.Close()
+			return nil, fmt.Errorf("fail to read input file: %v", err)
+		}
+		if size > 0 {
+			size -= c.InputOffset
+		}
+	}
+	c.saved = time.Now()
+
+	src := singleSource(inputSequence, &output{w: out})
+	src.checkpoint = c
+	err = translate(ctx, []*source{src}, size, options)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Rename(partial, outseq)
+	if err != nil {
+		return nil, err
+	}
+	return []string{outseq}, os.Remove(name)
+}
+
+// resumeOutput opens the output of the run to resume, without the
+// records written after the checkpoint
+func (c *checkpoint) resumeOutput(name string) (*os.File, error) {
+
+	f, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err == nil && info.Size() < c.OutputSize {
+		// truncating would pad the output with zeros
+		err = fmt.Errorf("can't resume the run saved in %s, %s has %d bytes instead of at least %d", c.name, name, info.Size(), c.OutputSize)
+	}
+	if err == nil {
+		err = f.Truncate(c.OutputSize)
+	}
+	if err == nil {
+		_, err = f.Seek(c.OutputSize, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// skipInput skips the first n bytes of the input, already translated
+func skipInput(in io.Reader, n int64) error {
+
+	if s, ok := in.(io.Seeker); ok {
+		_, err := s.Seek(n, io.SeekStart)
+		return err
+	}
+	_, err := io.CopyN(ioutil.Discard, in, n)
+	return err
+}
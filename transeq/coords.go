@@ -0,0 +1,122 @@
+package transeq
+
+import "fmt"
+
+// frameOffset returns the nb of nucleotides to skip from the start of the
+// strand of the frame to get to the first codon of the frame
+func frameOffset(frameIndex, seqLen int, alternative bool) int {
+
+	if frameIndex < 3 || alternative {
+		return frameIndex % 3
+	}
+	// Staden convention: Frame -1 is the reverse-complement of the sequence
+	// having the same codon phase as frame 1. Frame -2 is the same phase as
+	// frame 2. Frame -3 is the same phase as frame 3
+	//
+	// use the matrix to keep track of the forward frame as it depends on the
+	// length of the sequence
+	staden := [3][3]int{
+		{0, 2, 1},
+		{1, 0, 2},
+		{2, 1, 0},
+	}
+	return staden[seqLen%3][frameIndex-3]
+}
+
+// nuclRange returns the 1-based coordinates on the forward strand of the
+// nucleotides coding for the amino acids in [start, end) of the translation
+// of a frame. For frames on the reverse strand, from is greater than to.
+//
+// For circular sequences, coordinates are given modulo the length of the
+// sequence, so the order of from and to is reversed for ranges crossing
+// the origin
+func nuclRange(frameIndex, offset, seqLen, start, end int, circular bool) (from, to int) {
+
+	// 0-based positions on the strand of the frame of the first
+	// and last nucleotide of the range
+	first := offset + 3*start
+	last := offset + 3*end - 1
+	if circular && seqLen > 0 {
+		first, last = first%seqLen, last%seqLen
+	}
+	if first < 0 {
+		// the first codon is incomplete
+		first = 0
+	}
+	if last > seqLen-1 {
+		// the last codon is incomplete
+		last = seqLen - 1
+	}
+
+	if frameIndex < 3 {
+		return first + 1, last + 1
+	}
+	return seqLen - first, seqLen - last
+}
+
+// frameIndexFromName returns the index of a frame in [0, 6) from
+// its name in [1, 2, 3, -1, -2, -3]
+func frameIndexFromName(frame int) (int, error) {
+	switch {
+	case frame >= 1 && frame <= 3:
+		return frame - 1, nil
+	case frame >= -3 && frame <= -1:
+		return 2 - frame, nil
+	}
+	return 0, fmt.Errorf("invalid frame: %d", frame)
+}
+
+// CodonRange returns the 1-based coordinates on the forward strand of the
+// codon coding for the amino acid at 1-based position aaPos in the
+// translation of frame, for a nucleic sequence of length seqLen.
+//
+// frame is one of [1, 2, 3, -1, -2, -3], and alternative has the same
+// meaning as Options.Alternative. For frames on the reverse strand, from
+// is greater than to. If the last codon of the frame is incomplete, its
+// range is truncated to the end of the sequence. Incomplete leading codons
+// are assumed to be dropped, as with the default value of
+// Options.LeadingPartial
+func CodonRange(frame, aaPos, seqLen int, alternative bool) (from, to int, err error) {
+
+	frameIndex, err := frameIndexFromName(frame)
+	if err != nil {
+		return 0, 0, err
+	}
+	offset := frameOffset(frameIndex, seqLen, alternative)
+	// the translation of the frame includes the last codon even
+	// if it's incomplete
+	nbAA := (seqLen - offset + 2) / 3
+	if aaPos < 1 || aaPos > nbAA {
+		return 0, 0, fmt.Errorf("amino acid position %d out of range [1, %d] for frame %d", aaPos, nbAA, frame)
+	}
+	from, to = nuclRange(frameIndex, offset, seqLen, aaPos-1, aaPos, false)
+	return from, to, nil
+}
+
+// AAPosition returns the 1-based position in the translation of frame of
+// the amino acid coded by the codon containing the nucleotide at 1-based
+// position ntPos on the forward strand, for a nucleic sequence of length
+// seqLen.
+//
+// frame and alternative have the same meaning as in CodonRange. An error
+// is returned if the nucleotide is not part of any codon of the frame
+func AAPosition(frame, ntPos, seqLen int, alternative bool) (aaPos int, err error) {
+
+	frameIndex, err := frameIndexFromName(frame)
+	if err != nil {
+		return 0, err
+	}
+	if ntPos < 1 || ntPos > seqLen {
+		return 0, fmt.Errorf("nucleotide position %d out of range [1, %d]", ntPos, seqLen)
+	}
+	// 0-based position of the nucleotide on the strand of the frame
+	pos := ntPos - 1
+	if frameIndex >= 3 {
+		pos = seqLen - ntPos
+	}
+	offset := frameOffset(frameIndex, seqLen, alternative)
+	if pos < offset {
+		return 0, fmt.Errorf("nucleotide %d is before the first codon of frame %d", ntPos, frame)
+	}
+	return (pos-offset)/3 + 1, nil
+}
@@ -14,32 +14,77 @@ import (
 // s[headerSize:] stores the nucleic sequence
 type encodedSequence []byte
 
-func newEncodedSequence(buf *bytes.Buffer, headerSize int) encodedSequence {
+// newEncodedSequence encodes a raw fasta record, ie an optional header
+// line starting with '>' followed by the lines of the nucleic sequence,
+// and returns the nb of invalid characters replaced by 'N'
+func newEncodedSequence(record []byte) (encodedSequence, int) {
 
-	s := getSizedSlice(4 + buf.Len())
+	// the encoded sequence can't be longer than the record
+	s := getSizedSlice(4 + len(record))
+
+	headerSize := 4
+	if len(record) > 0 && record[0] == '>' {
+		end := bytes.IndexByte(record, '\n')
+		if end == -1 {
+			end = len(record)
+		}
+		headerSize += copy(s[4:], trimCR(record[:end]))
+		record = record[end:]
+	}
 	// reserve 4 bytes to store the header size as an uint32
-	headerSize += 4
 	binary.LittleEndian.PutUint32(s[0:4], uint32(headerSize))
-	copy(s[4:], buf.Bytes())
-
-	for i, n := range s[headerSize:] {
-		switch n {
-		case 'A', 'a':
-			s[headerSize+i] = aCode
-		case 'C', 'c':
-			s[headerSize+i] = cCode
-		case 'G', 'g':
-			s[headerSize+i] = gCode
-		case 'T', 'U', 't', 'u':
-			s[headerSize+i] = tCode
-		case 'N', 'n':
-			s[headerSize+i] = nCode
-		default:
-			s[headerSize+i] = nCode
-			fmt.Printf("WARNING: invalid char in sequence %s: '%s' ( pos %d), replacing with 'N'\n", string(s[:headerSize]), string(n), i)
+
+	pos, invalid := headerSize, 0
+	for len(record) > 0 {
+		end := bytes.IndexByte(record, '\n')
+		if end == -1 {
+			end = len(record)
+		}
+		for _, n := range trimCR(record[:end]) {
+			code := nuclCodes[n]
+			if code == invalidCode {
+				code = nCode
+				invalid++
+				fmt.Printf("WARNING: invalid char in sequence %s: '%s' ( pos %d), replacing with 'N'\n", string(s[4:headerSize]), string(n), pos-headerSize)
+			}
+			s[pos] = code
+			pos++
 		}
+		if end < len(record) {
+			end++
+		}
+		record = record[end:]
+	}
+	return s[:pos], invalid
+}
+
+// trimCR removes the '\r' at the end of lines with windows line endings
+func trimCR(line []byte) []byte {
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		return line[:len(line)-1]
+	}
+	return line
+}
+
+// code of each nucleotide letter, invalidCode for other characters
+var nuclCodes = func() (codes [256]uint8) {
+	for i := range codes {
+		codes[i] = invalidCode
+	}
+	for letter, code := range letterCode {
+		codes[letter] = code
+		codes[letter+'a'-'A'] = code
 	}
-	return s
+	return codes
+}()
+
+// complement of each nucleotide code, N being its own complement
+var complements = [1 << nuclBits]uint8{
+	nCode: nCode,
+	aCode: tCode,
+	cCode: gCode,
+	tCode: aCode,
+	gCode: cCode,
 }
 
 var pool = sync.Pool{
@@ -56,6 +101,14 @@ func getSizedSlice(size int) encodedSequence {
 	return s[:size]
 }
 
+// putSequence returns the slice of a sequence to the pool, unless it's
+// bigger than maxSize
+func putSequence(s encodedSequence, maxSize int) {
+	if cap(s) <= maxSize {
+		pool.Put(s)
+	}
+}
+
 func (s encodedSequence) header() []byte {
 	return s[4:s.headerSize()]
 }
@@ -64,6 +117,12 @@ func (s encodedSequence) headerSize() int {
 	return int(binary.LittleEndian.Uint32(s[0:4]))
 }
 
+// codonIndexAt returns the index in the codon <-> AA array of the
+// codon starting at pos
+func (s encodedSequence) codonIndexAt(pos int) uint32 {
+	return codonIndex(s[pos], s[pos+1], s[pos+2])
+}
+
 func (s encodedSequence) nuclSeqSize() int {
 	return len(s) - s.headerSize()
 }
@@ -71,27 +130,15 @@ func (s encodedSequence) nuclSeqSize() int {
 func (s encodedSequence) reverseComplement() {
 
 	headerSize := s.headerSize()
-	// get the complementary sequence.
-	// Basically, switch
+	// reverse the sequence and get the complementary sequence in a
+	// single pass. Basically, switch
 	//   A <-> T
 	//   C <-> G
-	for i, n := range s[headerSize:] {
-		switch n {
-		case aCode:
-			s[headerSize+i] = tCode
-		case tCode:
-			// handle both tCode and uCode
-			s[headerSize+i] = aCode
-		case cCode:
-			s[headerSize+i] = gCode
-		case gCode:
-			s[headerSize+i] = cCode
-		default:
-			// case N -> leave it
-		}
+	i, j := headerSize, len(s)-1
+	for ; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = complements[s[j]&(1<<nuclBits-1)], complements[s[i]&(1<<nuclBits-1)]
 	}
-	// reverse the sequence
-	for i, j := headerSize, len(s)-1; i < j; i, j = i+1, j-1 {
-		s[i], s[j] = s[j], s[i]
+	if i == j {
+		s[i] = complements[s[i]&(1<<nuclBits-1)]
 	}
 }
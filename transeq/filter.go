@@ -0,0 +1,148 @@
+package transeq
+
+import "bytes"
+
+// criteria to select the best frame of a sequence
+const (
+	// fewest internal stop codons, then lowest fraction of 'X', then longest
+	bestScore = "score"
+	// longest stretch without stop codons
+	bestStretch = "stretch"
+	// longest stretch starting with a methionine and without stop codons
+	bestORF = "orf"
+)
+
+// policies for incomplete codons at the start or at the end of a frame
+const (
+	// guess the AA from the available nucleotides if all the possible
+	// codons code for the same AA, 'X' otherwise
+	partialGuess = "guess"
+	// always translate incomplete codons as 'X'
+	partialUnknown = "x"
+	// don't translate incomplete codons
+	partialDrop = "drop"
+)
+
+// frameStats holds the properties of a translated frame used to
+// filter and rank frames
+type frameStats struct {
+	length  int
+	unknown int
+	// nb of stop codons, excluding a stop codon at the end of the frame
+	internalStops int
+	// range of the longest stretch or ORF of the frame, depending on
+	// the criterion used to select the best frame
+	regionStart, regionEnd int
+}
+
+func newFrameStats(prot []byte) frameStats {
+
+	s := frameStats{
+		length:        len(prot),
+		unknown:       bytes.Count(prot, []byte{unknown}),
+		internalStops: bytes.Count(prot, []byte{stop}),
+	}
+	if len(prot) > 0 && prot[len(prot)-1] == stop {
+		s.internalStops--
+	}
+	return s
+}
+
+func (s frameStats) unknownFraction() float64 {
+	if s.length == 0 {
+		return 0
+	}
+	return float64(s.unknown) / float64(s.length)
+}
+
+// betterThan reports whether s scores better than o according to
+// the criterion
+func (s frameStats) betterThan(o frameStats, criterion string) bool {
+	if criterion == bestStretch || criterion == bestORF {
+		return s.regionEnd-s.regionStart > o.regionEnd-o.regionStart
+	}
+	if s.internalStops != o.internalStops {
+		return s.internalStops < o.internalStops
+	}
+	if s.unknownFraction() != o.unknownFraction() {
+		return s.unknownFraction() < o.unknownFraction()
+	}
+	return s.length > o.length
+}
+
+// filter discards translated frames that are unlikely to be actual
+// proteins
+type filter struct {
+	minLength  int
+	maxUnknown float64
+	// a negative value disables the filter
+	maxStops int
+}
+
+// newFilter returns the filter of the options. Filters not set in
+// options, as in a zero value Options, keep all the frames
+func newFilter(options Options) filter {
+	f := filter{
+		minLength:  options.MinLength,
+		maxUnknown: 1,
+		maxStops:   -1,
+	}
+	if options.MaxUnknown != nil {
+		f.maxUnknown = *options.MaxUnknown
+	}
+	if options.MaxStops != nil {
+		f.maxStops = *options.MaxStops
+	}
+	return f
+}
+
+func (f filter) keep(s frameStats) bool {
+	if s.length < f.minLength {
+		return false
+	}
+	if s.unknownFraction() > f.maxUnknown {
+		return false
+	}
+	if f.maxStops >= 0 && s.internalStops > f.maxStops {
+		return false
+	}
+	return true
+}
+
+// longestStretch returns the range of the longest stretch of prot without
+// stop codons. In case of tie, the first stretch is returned
+func longestStretch(prot []byte) (start, end int) {
+
+	current := 0
+	for i, aa := range prot {
+		if aa == stop {
+			current = i + 1
+			continue
+		}
+		if i+1-current > end-start {
+			start, end = current, i+1
+		}
+	}
+	return start, end
+}
+
+// longestORF returns the range of the longest stretch of prot starting
+// with a methionine and ending before a stop codon or at the end of the
+// translation. In case of tie, the first ORF is returned
+func longestORF(prot []byte) (start, end int) {
+
+	current := -1
+	for i, aa := range prot {
+		switch {
+		case aa == stop:
+			current = -1
+			continue
+		case aa == 'M' && current == -1:
+			current = i
+		}
+		if current != -1 && i+1-current > end-start {
+			start, end = current, i+1
+		}
+	}
+	return start, end
+}
@@ -0,0 +1,350 @@
+package transeq
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/feliixx/gotranseq/ncbicode"
+)
+
+// minimal length, in codons, of the stop-free stretches where reassigned
+// codons are counted
+const inferMinStretch = 30
+
+// candidate is a NCBI code tested during inference
+type candidate struct {
+	code  int
+	codes *[arrayCodeSize]byte
+	// codons translated differently than with the standard code
+	reassigned []reassignedCodon
+}
+
+type reassignedCodon struct {
+	index uint32
+	// codon and its translation with the candidate code, for
+	// example 'TGA:W'
+	name string
+}
+
+// evidence collected for a candidate
+type evidence struct {
+	internalStops int
+	// occurrences of each reassigned codon in long stop-free
+	// stretches, in the same order as candidate.reassigned
+	reassignedCounts []int
+}
+
+// support returns the nb of reassigned codons never observed in long
+// stop-free stretches, and the total nb of occurrences of the reassigned
+// codons
+func (e evidence) support() (unsupported, observed int) {
+	for _, n := range e.reassignedCounts {
+		if n == 0 {
+			unsupported++
+		}
+		observed += n
+	}
+	return unsupported, observed
+}
+
+func (e *evidence) add(o evidence) {
+	e.internalStops += o.internalStops
+	for i, n := range o.reassignedCounts {
+		e.reassignedCounts[i] += n
+	}
+}
+
+func newCandidates(tables *codeTables) ([]candidate, error) {
+
+	standard, err := ncbicode.LoadTableCode(ncbicode.Standard)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []candidate
+	for _, code := range ncbicode.TableCodes() {
+
+		codeMap, err := ncbicode.LoadTableCode(code)
+		if err != nil {
+			return nil, err
+		}
+		c := candidate{code: code, codes: tables.arrays[code]}
+		for codon, aaCode := range codeMap {
+			if aaCode == standard[codon] {
+				continue
+			}
+			index := codonIndex(letterCode[codon[0]], letterCode[codon[1]], letterCode[codon[2]])
+			c.reassigned = append(c.reassigned, reassignedCodon{
+				index: index,
+				name:  codon + ":" + string(aaCode),
+			})
+		}
+		sort.Slice(c.reassigned, func(i, j int) bool {
+			return c.reassigned[i].name < c.reassigned[j].name
+		})
+		candidates = append(candidates, c)
+	}
+	return candidates, nil
+}
+
+// inferrer collects the evidence for each candidate code. For each code,
+// only the frame with the fewest internal stop codons is considered
+type inferrer struct {
+	w          *writer
+	candidates []candidate
+	// evidence by candidate and by frame for the current sequence
+	frames [][6]evidence
+	// evidence by candidate for all the sequences processed
+	total []evidence
+}
+
+func newInferrer(w *writer, candidates []candidate) *inferrer {
+	inf := &inferrer{
+		w:          w,
+		candidates: candidates,
+		frames:     make([][6]evidence, len(candidates)),
+		total:      make([]evidence, len(candidates)),
+	}
+	for i, c := range candidates {
+		inf.total[i].reassignedCounts = make([]int, len(c.reassigned))
+		for f := range inf.frames[i] {
+			inf.frames[i][f].reassignedCounts = make([]int, len(c.reassigned))
+		}
+	}
+	return inf
+}
+
+// infer returns the evidence for each candidate for this sequence
+func (inf *inferrer) infer(sequence encodedSequence) []evidence {
+
+	w := inf.w
+	w.seqLen = sequence.nuclSeqSize()
+
+	for firstFrame := 0; firstFrame < 6; firstFrame += 3 {
+
+		if firstFrame == 3 {
+			if !w.reverse {
+				break
+			}
+			sequence.reverseComplement()
+		}
+		for i, c := range inf.candidates {
+			w.codes = c.codes
+			w.translate3Frames(sequence, firstFrame)
+			for frameIndex := firstFrame; frameIndex < firstFrame+3; frameIndex++ {
+				if w.framesToGenerate[frameIndex] == 1 {
+					inf.collect(sequence, c, frameIndex, &inf.frames[i][frameIndex])
+				}
+			}
+		}
+	}
+
+	result := make([]evidence, len(inf.candidates))
+	for i := range inf.candidates {
+		best := -1
+		for frameIndex := range inf.frames[i] {
+			if w.framesToGenerate[frameIndex] == 0 {
+				continue
+			}
+			if best == -1 || inf.frames[i][frameIndex].internalStops < inf.frames[i][best].internalStops {
+				best = frameIndex
+			}
+		}
+		result[i] = evidence{
+			internalStops:    inf.frames[i][best].internalStops,
+			reassignedCounts: append([]int(nil), inf.frames[i][best].reassignedCounts...),
+		}
+		inf.total[i].add(result[i])
+	}
+	return result
+}
+
+// collect counts the internal stop codons of a frame, and the reassigned
+// codons of the candidate in the long stop-free stretches of this frame
+func (inf *inferrer) collect(sequence encodedSequence, c candidate, frameIndex int, e *evidence) {
+
+	prot := inf.w.prots[frameIndex]
+	e.internalStops = newFrameStats(prot).internalStops
+	for i := range e.reassignedCounts {
+		e.reassignedCounts[i] = 0
+	}
+	if len(c.reassigned) == 0 {
+		return
+	}
+
+	firstCodon := sequence.headerSize() + inf.w.offsets[frameIndex]
+	start := 0
+	for end := 0; end <= len(prot); end++ {
+
+		if end < len(prot) && prot[end] != stop {
+			continue
+		}
+		if end-start >= inferMinStretch {
+			for i := start; i < end; i++ {
+				pos := firstCodon + 3*i
+				if pos < sequence.headerSize() {
+					// incomplete leading codon
+					continue
+				}
+				if pos+2 >= len(sequence) {
+					break
+				}
+				index := sequence.codonIndexAt(pos)
+				for k, r := range c.reassigned {
+					if r.index == index {
+						e.reassignedCounts[k]++
+					}
+				}
+			}
+		}
+		start = end + 1
+	}
+}
+
+// writeRanking appends the candidates ranked by consistency with the
+// evidence to buf, one line per candidate
+func (inf *inferrer) writeRanking(buf []byte, name []byte, result []evidence) []byte {
+
+	order := make([]int, len(inf.candidates))
+	for i := range order {
+		order[i] = i
+	}
+	// fewest internal stop codons first, then the code with the fewest
+	// reassignments not supported by the codon usage, the fewest
+	// reassignments compared to the standard code, and the most
+	// occurrences of its reassigned codons
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := order[i], order[j]
+		if result[a].internalStops != result[b].internalStops {
+			return result[a].internalStops < result[b].internalStops
+		}
+		unsupportedA, observedA := result[a].support()
+		unsupportedB, observedB := result[b].support()
+		if unsupportedA != unsupportedB {
+			return unsupportedA < unsupportedB
+		}
+		if len(inf.candidates[a].reassigned) != len(inf.candidates[b].reassigned) {
+			return len(inf.candidates[a].reassigned) < len(inf.candidates[b].reassigned)
+		}
+		return observedA > observedB
+	})
+
+	for rank, i := range order {
+		c := inf.candidates[i]
+		buf = append(buf, name...)
+		buf = append(buf, '\t')
+		buf = strconv.AppendInt(buf, int64(rank+1), 10)
+		buf = append(buf, '\t')
+		buf = strconv.AppendInt(buf, int64(c.code), 10)
+		buf = append(buf, '\t')
+		buf = strconv.AppendInt(buf, int64(result[i].internalStops), 10)
+		buf = append(buf, '\t')
+		if len(c.reassigned) == 0 {
+			buf = append(buf, '-')
+		}
+		for k, r := range c.reassigned {
+			if k > 0 {
+				buf = append(buf, ',')
+			}
+			buf = append(buf, r.name...)
+			buf = append(buf, '=')
+			buf = strconv.AppendInt(buf, int64(result[i].reassignedCounts[k]), 10)
+		}
+		buf = append(buf, '\n')
+	}
+	return buf
+}
+
+// header of the report written by InferTable
+const inferHeader = "sequence\trank\ttable\tinternal_stops\treassigned_codons\n"
+
+// InferTable translates the sequences with every available NCBI code, and
+// writes a tab separated report ranking the codes by consistency with the
+// sequences.
+//
+// For each code, only the frame with the fewest internal stop codons among
+// the frames selected in options is considered. The codon usage is
+// measured by the occurrences, in stop-free stretches of at least 30
+// codons, of each codon translated differently than with the standard
+// code, which are given in the report.
+//
+// Codes are ranked by number of internal stop codons, then by number of
+// reassigned codons never observed, as a reassignment is only supported
+// by the codon usage if the codon is used. Remaining ties are broken in
+// favor of the code closest to the standard code, and then of the code
+// whose reassigned codons are the most used.
+//
+// If bySequence is true, a ranking is written for each sequence, otherwise
+// a single ranking named 'all' is written for the whole input
+func InferTable(inputSequence io.Reader, out io.Writer, options Options, bySequence bool) error {
+	return InferTableContext(context.Background(), inputSequence, out, options, bySequence)
+}
+
+// InferTableContext is like InferTable, but stops once ctx is cancelled,
+// and returns ctx.Err() in this case, as TranslateContext does
+func InferTableContext(ctx context.Context, inputSequence io.Reader, out io.Writer, options Options, bySequence bool) error {
+
+	framesToGenerate, reverse, err := computeFrames(options.Frame)
+	if err != nil {
+		return err
+	}
+
+	tables, err := newCodeTables(Options{TableModifiers: true})
+	if err != nil {
+		return err
+	}
+	candidates, err := newCandidates(tables)
+	if err != nil {
+		return err
+	}
+
+	_, err = out.Write([]byte(inferHeader))
+	if err != nil {
+		return fmt.Errorf("fail to write to output file: %v", err)
+	}
+
+	var mu sync.Mutex
+	total := newInferrer(nil, candidates).total
+
+	err = process(ctx, inputSequence, out, options, func(o *output) worker {
+
+		w := newWriter(tables, framesToGenerate, reverse, nil, withDefaults(Options{Alternative: options.Alternative}), o)
+		inf := newInferrer(w, candidates)
+
+		return worker{
+			sequence: func(sequence encodedSequence) {
+				result := inf.infer(sequence)
+				if bySequence {
+					w.buf = inf.writeRanking(w.buf, sequenceID(sequence.header()), result)
+					if len(w.buf) > o.bufferSize {
+						w.flush()
+					}
+				}
+			},
+			done: func() {
+				w.flush()
+
+				mu.Lock()
+				for i := range total {
+					total[i].add(inf.total[i])
+				}
+				mu.Unlock()
+			},
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if !bySequence {
+		_, err = out.Write(newInferrer(nil, candidates).writeRanking(nil, []byte("all"), total))
+		if err != nil {
+			return fmt.Errorf("fail to write to output file: %v", err)
+		}
+	}
+	return nil
+}
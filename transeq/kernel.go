@@ -0,0 +1,34 @@
+package transeq
+
+// translateCodons appends the translation of the complete codons of seq
+// to prot.
+//
+// Codons are translated four at a time: the codon <-> AA array is only 512
+// bytes long, and the index of a codon is masked to the size of the array,
+// so the lookups don't need bounds checks and don't depend on each other
+func translateCodons(codes *[arrayCodeSize]byte, seq []byte, prot []byte) []byte {
+
+	nbCodons := len(seq) / 3
+	start := len(prot)
+	if cap(prot)-start < nbCodons {
+		grown := make([]byte, start, start+nbCodons)
+		copy(grown, prot)
+		prot = grown
+	}
+	prot = prot[:start+nbCodons]
+	out := prot[start:]
+
+	i := 0
+	for ; i+4 <= len(out); i += 4 {
+		s := seq[3*i : 3*i+12 : 3*i+12]
+		out[i] = codes[codonIndex(s[0], s[1], s[2])&(arrayCodeSize-1)]
+		out[i+1] = codes[codonIndex(s[3], s[4], s[5])&(arrayCodeSize-1)]
+		out[i+2] = codes[codonIndex(s[6], s[7], s[8])&(arrayCodeSize-1)]
+		out[i+3] = codes[codonIndex(s[9], s[10], s[11])&(arrayCodeSize-1)]
+	}
+	for ; i < len(out); i++ {
+		s := seq[3*i : 3*i+3 : 3*i+3]
+		out[i] = codes[codonIndex(s[0], s[1], s[2])&(arrayCodeSize-1)]
+	}
+	return prot
+}
@@ -0,0 +1,120 @@
+package transeq
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	// smallest chunk of input and output buffer allowed by a memory
+	// budget
+	minBufferSize = 64 << 10
+	// interval between two measures of the memory used
+	memorySampling = 50 * time.Millisecond
+)
+
+// memoryBudget gives the size of the buffers of the pipeline, so the
+// memory used stays under Options.MaxMemory
+type memoryBudget struct {
+	maxMemory ByteSize
+	// size of the chunks of input
+	chunkSize int
+	// nb of chunks read in advance, waiting for a worker
+	queueSize int
+	// size above which a worker writes its buffer to the output
+	bufferSize int
+	// size of the largest record accepted, or 0 if no budget is set
+	maxRecordSize int
+
+	// peak memory, sampled while the input is processed
+	peak    uint64
+	done    chan struct{}
+	sampler sync.WaitGroup
+}
+
+func newMemoryBudget(maxMemory ByteSize, numWorker int) (*memoryBudget, error) {
+
+	b := &memoryBudget{
+		maxMemory:  maxMemory,
+		chunkSize:  chunkSize,
+		queueSize:  2 * numWorker,
+		bufferSize: maxBufferSize,
+	}
+	if maxMemory == 0 {
+		return b, nil
+	}
+
+	// Each worker holds a chunk waiting in the queue, the chunk it's
+	// parsing, the encoded records of this chunk, the translation of
+	// the six frames of a record, ie twice its length, and its output
+	// buffer. The reader holds one more chunk. Only half of the budget
+	// is used for these buffers, as the garbage collector lets the heap
+	// grow up to twice the size of the live data
+	unit := int(maxMemory) / (2 * (6*numWorker + 1))
+	if unit < minBufferSize {
+		return nil, fmt.Errorf("wrong value for --max-memory parameter: %v is too small for %d workers, use at least %v or less workers",
+			maxMemory, numWorker, ByteSize(2*(6*numWorker+1)*minBufferSize))
+	}
+	if unit < b.chunkSize {
+		b.chunkSize = unit
+	}
+	if unit < b.bufferSize {
+		b.bufferSize = unit
+	}
+	b.queueSize = numWorker
+	// a record is held by the chunk it's read in and once encoded, and
+	// the translations of its six frames take twice its size. As the
+	// heap grows up to twice the size of the live data, a record needs
+	// up to eight times its size
+	b.maxRecordSize = int(maxMemory) / 8
+	return b, nil
+}
+
+// startMonitor starts sampling the memory used by the process, if a
+// budget is set
+func (b *memoryBudget) startMonitor() {
+
+	if b.maxMemory == 0 {
+		return
+	}
+	b.done = make(chan struct{})
+	b.sampler.Add(1)
+	go func() {
+		defer b.sampler.Done()
+		ticker := time.NewTicker(memorySampling)
+		defer ticker.Stop()
+		for {
+			b.sample()
+			select {
+			case <-ticker.C:
+			case <-b.done:
+				return
+			}
+		}
+	}()
+}
+
+// sample records the memory obtained from the OS and not released yet,
+// which is close to the resident memory of the process
+func (b *memoryBudget) sample() {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	if used := stats.Sys - stats.HeapReleased; used > b.peak {
+		b.peak = used
+	}
+}
+
+// stopMonitor stops sampling the memory used, and returns the peak
+// memory, or 0 if no budget is set. It can be called several times
+func (b *memoryBudget) stopMonitor() int64 {
+
+	if b.done != nil {
+		close(b.done)
+		b.sampler.Wait()
+		b.done = nil
+		b.sample()
+	}
+	return int64(b.peak)
+}
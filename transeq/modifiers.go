@@ -0,0 +1,48 @@
+package transeq
+
+import "bytes"
+
+// headerModifier returns the value of a NCBI style modifier from a
+// sequence header, for example with key 'gcode'
+//
+//	>sequenceID [organism=Homo sapiens] [gcode=2] comment
+//
+// returns '2'. ok is false if the header doesn't contain this modifier
+func headerModifier(seqHeader []byte, key string) (value []byte, ok bool) {
+
+	for {
+		start := bytes.IndexByte(seqHeader, '[')
+		if start == -1 {
+			return nil, false
+		}
+		seqHeader = seqHeader[start+1:]
+		end := bytes.IndexByte(seqHeader, ']')
+		if end == -1 {
+			return nil, false
+		}
+		modifier := seqHeader[:end]
+		seqHeader = seqHeader[end+1:]
+
+		sep := bytes.IndexByte(modifier, '=')
+		if sep == -1 {
+			continue
+		}
+		if string(bytes.TrimSpace(modifier[:sep])) == key {
+			return bytes.TrimSpace(modifier[sep+1:]), true
+		}
+	}
+}
+
+// sequenceID returns the id of the sequence from its header, ie
+// everything between '>' and the first space
+func sequenceID(seqHeader []byte) []byte {
+	if len(seqHeader) == 0 {
+		// sequence before the first header
+		return nil
+	}
+	id := seqHeader[1:]
+	if end := bytes.IndexByte(id, ' '); end != -1 {
+		id = id[:end]
+	}
+	return id
+}
@@ -1,11 +1,131 @@
 package transeq
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/feliixx/gotranseq/ncbicode"
+)
+
 // Options struct to store required command line args
 type Options struct {
-	Frame       string `short:"f" long:"frame" value-name:"<code>" description:"Frame to translate. Possible values:\n  [1, 2, 3, F, -1, -2, -3, R, 6]\n F: forward three frames\n R: reverse three frames\n 6: all 6 frames\n" default:"1"`
-	Table       int    `short:"t" long:"table" value-name:"<code>" description:"NCBI code to use, see https://www.ncbi.nlm.nih.gov/Taxonomy/Utils/wprintgc.cgi?chapter=tgencodes#SG1 for details. Available codes: \n 0: Standard code\n 2: The Vertebrate Mitochondrial Code\n 3: The Yeast Mitochondrial Code\n 4: The Mold, Protozoan, and Coelenterate Mitochondrial Code and the Mycoplasma/Spiroplasma Code\n 5: The Invertebrate Mitochondrial Code\n 6: The Ciliate, Dasycladacean and Hexamita Nuclear Code\n 9: The Echinoderm and Flatworm Mitochondrial Code\n 10: The Euplotid Nuclear Code\n 11: The Bacterial, Archaeal and Plant Plastid Code\n 12: The Alternative Yeast Nuclear Code\n 13: The Ascidian Mitochondrial Code\n 14: The Alternative Flatworm Mitochondrial Code\n16: Chlorophycean Mitochondrial Code\n 21: Trematode Mitochondrial Code\n22: Scenedesmus obliquus Mitochondrial Code\n 23: Thraustochytrium Mitochondrial Code\n 24: Pterobranchia Mitochondrial Code\n 25: Candidate Division SR1 and Gracilibacteria Code\n 26: Pachysolen tannophilus Nuclear Code\n 29: Mesodinium Nuclear\n 30: Peritrich Nuclear\n" default:"0"`
-	Clean       bool   `short:"c" long:"clean" description:"Replace stop codon '*' by 'X'"`
-	Alternative bool   `short:"a" long:"alternative" description:"Define frame '-1' as using the set of codons starting with the last codon of the sequence"`
-	Trim        bool   `short:"T" long:"trim" description:"Removes all 'X' and '*' characters from the right end of the translation. The trimming process starts at the end and continues until the next character is not a 'X' or a '*'"`
-	NumWorker   int    `short:"n" long:"numcpu" value-name:"<n>" description:"Number of worker to use (default: number of CPU)"`
+	Frame           string    `short:"f" long:"frame" value-name:"<code>" description:"Frame(s) to translate, as a comma separated list of values. Possible values:\n  [1, 2, 3, F, -1, -2, -3, R, 6]\n F: forward three frames\n R: reverse three frames\n 6: all 6 frames\nForward frames can also be written as +1, +2 and +3. For example, '1,-2' translates frames 1 and -2\n" default:"1"`
+	Table           TableCode `short:"t" long:"table" value-name:"<code>" description:"NCBI code to use, by id, name or alias. Names and aliases are case insensitive, see https://www.ncbi.nlm.nih.gov/Taxonomy/Utils/wprintgc.cgi?chapter=tgencodes#SG1 for details" default:"0"`
+	TableMap        string    `long:"table-map" value-name:"<filename>" description:"Tab separated file of '<sequenceID>\\t<code>' lines giving the NCBI code to use for specific sequences, by id or alias. Sequences not listed use the code from --table"`
+	TableModifiers  bool      `long:"table-modifiers" description:"Read the NCBI code to use from '[transl_table=<code>]' or '[gcode=<code>]' modifiers in sequence headers. Codes from --table-map take precedence over modifiers, and sequences without modifier use the code from --table"`
+	Clean           bool      `short:"c" long:"clean" description:"Replace stop codon '*' by 'X'"`
+	Selenocysteine  bool      `long:"selenocysteine" description:"Translate TGA as selenocysteine 'U' if it is a stop codon in the selected table"`
+	Pyrrolysine     bool      `long:"pyrrolysine" description:"Translate TAG as pyrrolysine 'O' if it is a stop codon in the selected table"`
+	TranslExcept    string    `long:"transl-except" value-name:"<filename>" description:"Tab separated file of amino acids to force at specific positions, in the format of the INSDC /transl_except qualifier. Each line looks like\n  <sequenceID>\\t(pos:213..215,aa:Sec)\nor, for a codon on the reverse strand\n  <sequenceID>\\t(pos:complement(213..215),aa:Pyl)\nThe end of the range may be omitted for a single nucleotide, like in '(pos:1541,aa:TERM)'\n"`
+	CompleteStop    bool      `long:"complete-stop" description:"Translate a terminal 'T' or 'TA' as a stop codon '*', as for incomplete stop codons completed by polyadenylation in animal mitochondrial genes. To complete the stop codon of specific sequences only, use --transl-except with 'aa:TERM', for example '<sequenceID>\\t(pos:1540..1541,aa:TERM)'"`
+	LeadingPartial  string    `long:"leading-partial" value-name:"<policy>" description:"How to translate the incomplete codon at the start of frames that don't start with the first nucleotide of their strand, for example frame 2 of a CDS with phase 1. Possible values:\n drop: don't translate it\n x: translate it as 'X'. No codon of the NCBI codes can be guessed from its last nucleotides only\n" default:"drop"`
+	TrailingPartial string    `long:"trailing-partial" value-name:"<policy>" description:"How to translate the incomplete codon at the end of frames. Possible values:\n guess: translate it as the AA coded by all the codons starting with the available nucleotides, or 'X' if there is none. A single nucleotide is always translated as 'X', as EMBOSS transeq does\n x: translate it as 'X'\n drop: don't translate it, so the translation has exactly (length - offset) / 3 residues\n" default:"guess"`
+	Alternative     bool      `short:"a" long:"alternative" description:"Define frame '-1' as using the set of codons starting with the last codon of the sequence, as BLASTX and DIAMOND do. By default, frame '-1' has the same codon phase as frame '1' (Staden convention). Implied by --frame-names=signed"`
+	Trim            bool      `short:"T" long:"trim" description:"Removes all 'X' and '*' characters from the right end of the translation. The trimming process starts at the end and continues until the next character is not a 'X' or a '*'"`
+	FrameNames      string    `long:"frame-names" value-name:"<convention>" description:"Naming convention of the frames in the sequence id suffix. Possible values:\n emboss: frames 1, 2, 3, -1, -2, -3 are named 1, 2, 3, 4, 5, 6\n signed: frames are named +1, +2, +3, -1, -2, -3, and reverse frames use the offsets of BLASTX and DIAMOND, as with --alternative, so that a frame has the same name and codons as in their output\n" default:"emboss"`
+	TrimStop        bool      `long:"trim-stop" description:"Remove a single '*' from the right end of the translation"`
+	TrimLeadingX    bool      `long:"trim-leading-x" description:"Remove all 'X' characters from the left end of the translation"`
+	StopChar        string    `long:"stop-char" value-name:"<char>" description:"Character used for internal stop codons, ie all stop codons except the last residue of the translation (default: '*')"`
+	ReportStop      bool      `long:"report-stop" description:"Add 'stop=yes' to the comment if the translation ends with a stop codon before trimming, 'stop=no' otherwise"`
+	NumWorker       int       `short:"n" long:"numcpu" value-name:"<n>" description:"Number of worker to use (default: number of CPU)"`
+	MaxMemory       ByteSize  `long:"max-memory" value-name:"<size>" description:"Approximate memory budget, for example 512M or 2G. The size of the input chunks, of the queue of chunks waiting for a worker and of the output buffers is computed from it, and the output of large records is written incrementally. As a record and the translation of its frames have to fit in memory, records bigger than an eighth of the budget are rejected. The peak memory used is reported at the end of the run (default: no limit)"`
+	Progress        bool      `long:"progress" description:"Report the progress on stderr: nb of records and bases translated, throughput and, if the input is a regular file, percentage done and estimated time left. The progress is updated every second, on a single line if stderr is a terminal and on a new line otherwise"`
+	Summary         bool      `long:"summary" description:"Write a summary of the run to stderr at the end: nb of records read and written, bases translated, invalid characters replaced by 'N', stop codons in the translated frames, and nb of proteins written for each frame"`
+	SummaryJSON     string    `long:"summary-json" value-name:"<filename>" description:"Write the summary of the run to <filename> as a JSON object"`
+	MinLength       int       `long:"min-length" value-name:"<n>" description:"Discard frames shorter than <n> residues"`
+	MaxUnknown      *float64  `long:"max-x" value-name:"<fraction>" description:"Discard frames where the fraction of 'X' is greater than <fraction> (default: no limit)"`
+	MaxStops        *int      `long:"max-stops" value-name:"<n>" description:"Discard frames with more than <n> internal stop codons. A negative value disables this filter (default: no limit)"`
+	Best            string    `long:"best" value-name:"<criterion>" optional:"yes" optional-value:"score" description:"Only write the best frame of each sequence among the ones passing the filters. The selected frame is reported in the header as 'frame=<n>'. Possible criteria, 'score' being used if none is given:\n score: fewest internal stop codons, then lowest fraction of 'X', then longest translation\n stretch: longest stretch without stop codons\n orf: longest stretch starting with 'M' and without stop codons\nFrames without such a stretch are never selected, so sequences without any are not written. In case of tie, the first frame in the order 1, 2, 3, 4, 5, 6 is selected\n"`
+	Split           bool      `long:"split" description:"Split translations at stop codons and write each fragment as a separate record named <id>_<frame>_<aaStart>-<aaEnd>. The coordinates of the fragment on the forward strand are added to the comment as 'nt=<from>-<to>'"`
+	MinFragment     int       `long:"min-fragment" value-name:"<n>" description:"With --split, discard fragments shorter than <n> residues"`
+	// ORF, with Split, only writes the part of each fragment starting
+	// with its first methionine, as the orf command does
+	ORF          bool     `no-flag:"yes"`
+	Coords       bool     `long:"coords" description:"Add the strand of the frame, its offset (nb of nucleotides before the first codon on this strand) and the coordinates of the record on the forward strand to the comment as 'strand=<+|-> offset=<n> nt=<from>-<to>'. For frames on the reverse strand, <from> is greater than <to>. The offset is negative if the incomplete leading codon is translated, see --leading-partial"`
+	Circular     bool     `long:"circular" description:"Translate the sequences as circular molecules, like plasmids or organelle genomes. Frames continue from the start of the sequence after its end until the first stop codon, so ORFs crossing the origin are translated continuously. Coordinates are reported modulo the length of the sequence. Sequences can also be marked as circular or linear with a '[topology=circular|linear]' modifier in their header, which takes precedence over this flag"`
+	ByFrame      bool     `long:"by-frame" description:"Write each frame to a separate file named after --outseq, for example out.frame1.faa, or out.frame-1.faa with signed frame names"`
+	ByRecord     bool     `long:"by-record" description:"Write the translations of each input sequence to a separate file named after --outseq and the sequence id, for example out.seq1.faa"`
+	ShardRecords int      `long:"shard-records" value-name:"<n>" description:"Split the output in files of at most <n> records, named with --shard-pattern"`
+	ShardSize    ByteSize `long:"shard-size" value-name:"<size>" description:"Split the output in files of at most <size> bytes, for example 100M, named with --shard-pattern. A record bigger than <size> is written to its own file. Can be used with --shard-records"`
+	ShardPattern string   `long:"shard-pattern" value-name:"<pattern>" description:"Name of the output files when sharding, where a single %d verb is replaced by the shard number, starting at 1 (default: the --outseq name with the shard number before the extension, for example out.001.faa)"`
+	Force        bool     `long:"force" description:"Overwrite the output files if they already exist. Output files are written to a temporary file in the same directory, and renamed once complete, so an interrupted run never leaves a truncated output"`
+	Resume       bool     `long:"resume" description:"Write the records in input order, and save the progress of the run to <outseq>.checkpoint every second, so that a run that failed or was interrupted can be continued from the last record written by running the same command again with --resume. The output is written to <outseq>.partial until complete. Can't be used with several input or output files"`
+	// Stats, if not nil, is filled with the statistics of the run
+	Stats *Stats `no-flag:"yes" json:"-"`
+}
+
+// SplitsOutput reports whether the records are written to several files,
+// in which case TranslateToFiles has to be used instead of Translate
+func (o Options) SplitsOutput() bool {
+	return o.ByFrame || o.ByRecord || o.ShardRecords > 0 || o.ShardSize > 0
+}
+
+// TableCode is the id of a NCBI code. As a flag, it can also be given by
+// name or alias, see ncbicode.LookupTable
+type TableCode int
+
+// UnmarshalFlag implements flags.Unmarshaler
+func (c *TableCode) UnmarshalFlag(value string) error {
+	t, err := ncbicode.LookupTable(value)
+	if err != nil {
+		return err
+	}
+	*c = TableCode(t.ID)
+	return nil
+}
+
+// ByteSize is a number of bytes. As a flag, it can be given with a K, M
+// or G suffix, for example 512M
+type ByteSize int64
+
+// UnmarshalFlag implements flags.Unmarshaler
+func (s *ByteSize) UnmarshalFlag(value string) error {
+
+	v := strings.TrimSuffix(strings.ToUpper(value), "B")
+	unit := int64(1)
+	if v != "" {
+		switch v[len(v)-1] {
+		case 'K':
+			unit = 1 << 10
+		case 'M':
+			unit = 1 << 20
+		case 'G':
+			unit = 1 << 30
+		}
+		if unit != 1 {
+			v = v[:len(v)-1]
+		}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size: %s, expected a nb of bytes with an optional K, M or G suffix", value)
+	}
+	*s = ByteSize(n * unit)
+	return nil
+}
+
+// String implements fmt.Stringer
+func (s ByteSize) String() string {
+	return fmt.Sprintf("%.1f MB", float64(s)/mb)
+}
+
+// withDefaults returns a copy of options where empty string values are
+// replaced by the default value of the corresponding flag
+func withDefaults(options Options) Options {
+	if options.FrameNames == "" {
+		options.FrameNames = "emboss"
+	}
+	if options.FrameNames == "signed" {
+		// signed names are the ones of BLASTX and DIAMOND, so they
+		// refer to the same frames
+		options.Alternative = true
+	}
+	if options.LeadingPartial == "" {
+		options.LeadingPartial = partialDrop
+	}
+	if options.TrailingPartial == "" {
+		options.TrailingPartial = partialGuess
+	}
+	return options
 }
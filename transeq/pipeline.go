@@ -0,0 +1,182 @@
+package transeq
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// pipeline reads the inputs in chunks of complete records, and processes
+// the records of the chunks with a pool of workers
+type pipeline struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	budget *memoryBudget
+	chunks chan chunk
+	// first error met by the workers, see output.fail
+	errs chan error
+	wg   sync.WaitGroup
+}
+
+// worker holds the callbacks of a worker of a pipeline
+type worker struct {
+	// sequence is called for each record of the chunks received. The
+	// record is reused once it returns
+	sequence func(sequence encodedSequence)
+	// startChunk, if not nil, is called before the records of a chunk
+	// are processed, with the nb of invalid characters in the chunk
+	startChunk func(c chunk, invalid int)
+	// endChunk, if not nil, is called once all the records of a chunk
+	// are processed. The worker stops if it returns false
+	endChunk func(c chunk) bool
+	// done, if not nil, is called once all the chunks are processed,
+	// unless the pipeline is cancelled before
+	done func()
+}
+
+// newPipeline returns a pipeline with buffers sized after the memory
+// budget of options. It's cancelled with parent, or on the first error
+// of an output returned by p.output
+func newPipeline(parent context.Context, options Options) (*pipeline, error) {
+
+	budget, err := newMemoryBudget(options.MaxMemory, options.NumWorker)
+	if err != nil {
+		return nil, err
+	}
+	budget.startMonitor()
+
+	ctx, cancel := context.WithCancel(parent)
+	return &pipeline{
+		ctx:    ctx,
+		cancel: cancel,
+		budget: budget,
+		chunks: make(chan chunk, budget.queueSize),
+		errs:   make(chan error, 1),
+	}, nil
+}
+
+// output returns an output writing to w, with the buffer sizes of the
+// budget, that cancels the pipeline on error
+func (p *pipeline) output(w io.Writer) *output {
+	o := &output{w: w}
+	p.setOutput(o)
+	return o
+}
+
+// setOutput makes o cancel the pipeline on error, and sets its buffer
+// sizes from the budget
+func (p *pipeline) setOutput(o *output) {
+	o.bufferSize, o.retainSize = p.budget.bufferSize, p.budget.chunkSize
+	o.cancel, o.errs = p.cancel, p.errs
+}
+
+// start starts numWorker workers, each created by newWorker
+func (p *pipeline) start(numWorker int, newWorker func() worker) {
+
+	p.wg.Add(numWorker)
+	for nWorker := 0; nWorker < numWorker; nWorker++ {
+
+		go func() {
+
+			defer p.wg.Done()
+
+			w := newWorker()
+
+			var batch []encodedSequence
+			for c := range p.chunks {
+
+				var invalid int
+				batch, invalid = parseChunk(c.data, batch[:0])
+				putChunk(c.data, p.budget.chunkSize)
+				// the chunk of a large record is released before the
+				// record is processed
+				c.data = nil
+				if w.startChunk != nil {
+					w.startChunk(c, invalid)
+				}
+
+				for i, sequence := range batch {
+
+					if p.ctx.Err() != nil {
+						return
+					}
+
+					w.sequence(sequence)
+
+					putSequence(sequence, p.budget.chunkSize)
+					batch[i] = nil
+				}
+				if w.endChunk != nil && !w.endChunk(c) {
+					return
+				}
+			}
+			if w.done != nil {
+				w.done()
+			}
+		}()
+	}
+}
+
+// read sends the records of in, read from src, to the workers. It
+// returns without error once the pipeline is cancelled
+func (p *pipeline) read(in io.Reader, src *source) error {
+	return readChunks(p.ctx, in, p.chunks, p.budget.chunkSize, p.budget.maxRecordSize, src)
+}
+
+// wait waits for the workers once all the inputs are read, and returns
+// the first error met by the workers
+func (p *pipeline) wait() error {
+
+	close(p.chunks)
+	p.wg.Wait()
+
+	select {
+	case err, ok := <-p.errs:
+		if ok {
+			return err
+		}
+	default:
+	}
+	return nil
+}
+
+// stop releases the resources of the pipeline, and returns the peak
+// memory used, or 0 if no budget is set. It can be called several times
+func (p *pipeline) stop() int64 {
+	p.cancel()
+	return p.budget.stopMonitor()
+}
+
+// process reads the records of in, and processes them with
+// options.NumWorker workers created by newWorker, which write to out. It
+// returns ctx.Err() if ctx is cancelled before all the records are
+// processed
+func process(ctx context.Context, in io.Reader, out io.Writer, options Options, newWorker func(o *output) worker) error {
+
+	p, err := newPipeline(ctx, options)
+	if err != nil {
+		return err
+	}
+	defer p.stop()
+
+	o := p.output(out)
+	p.start(options.NumWorker, func() worker { return newWorker(o) })
+	readErr := p.read(in, singleSource(in, o))
+
+	err = p.wait()
+	if options.Stats != nil {
+		options.Stats.PeakMemory = p.stop()
+	}
+	if ctx.Err() != nil {
+		// errors caused by the cancellation are not reported
+		return ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+	if readErr != nil {
+		return fmt.Errorf("fail to read input file: %v", readErr)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package transeq
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// default size of the chunks of input read at once. A chunk holds as
+// many complete records as possible, and is grown if a single record
+// doesn't fit in it
+const chunkSize = 4 * mb
+
+var recordStart = []byte("\n>")
+
+// chunk is a block of input holding complete records
+type chunk struct {
+	data []byte
+	// input the chunk was read from
+	src *source
+	// position of the chunk in its input, and offset of its end
+	index int64
+	end   int64
+}
+
+var chunkPool sync.Pool
+
+// getChunk returns an empty chunk with a capacity of size. Chunks of
+// runs with another size are not reused
+func getChunk(size int) []byte {
+	if c, ok := chunkPool.Get().([]byte); ok && cap(c) == size {
+		return c[:0]
+	}
+	return make([]byte, 0, size)
+}
+
+// putChunk returns a chunk to the pool, unless it has been grown for a
+// record bigger than size
+func putChunk(c []byte, size int) {
+	if cap(c) == size {
+		chunkPool.Put(c[:0])
+	}
+}
+
+// readChunks reads the fasta input of src and sends it to chunks in
+// blocks of about size bytes, cut at record boundaries, so the records
+// can be parsed and encoded by several workers in parallel. A chunk is
+// grown for a record bigger than size, up to maxRecordSize if it's not 0.
+// It stops without error once ctx is cancelled.
+//
+// fasta format is:
+//
+// >sequenceID some comments on sequence
+// ACAGGCAGAGACACGACAGACGACGACACAGGAGCAGACAGCAGCAGACGACCACATATT
+// TTTGCGGTCACATGACGACTTCGGCAGCGA
+//
+// see https://blast.ncbi.nlm.nih.gov/Blast.cgi?CMD=Web&PAGE_TYPE=BlastDocs&DOC_TYPE=BlastHelp
+// section 1 for details
+func readChunks(ctx context.Context, in io.Reader, chunks chan<- chunk, size, maxRecordSize int, src *source) error {
+
+	buf := getChunk(size)
+	// position in buf before which no record start was found
+	searched := 0
+
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if len(buf) == cap(buf) {
+			// the current record is bigger than the chunk
+			grownSize := 2 * cap(buf)
+			if maxRecordSize > 0 && grownSize > maxRecordSize {
+				grownSize = maxRecordSize
+			}
+			if grownSize <= len(buf) {
+				return fmt.Errorf("a record is bigger than %v, the largest record allowed by --max-memory", ByteSize(maxRecordSize))
+			}
+			grown := make([]byte, len(buf), grownSize)
+			copy(grown, buf)
+			buf = grown
+		}
+
+		n, err := in.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+
+		if err == io.EOF {
+			if len(buf) > 0 {
+				sendChunk(ctx, chunks, chunk{data: buf, src: src})
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(buf) < cap(buf) {
+			continue
+		}
+
+		cut := bytes.LastIndex(buf[searched:], recordStart)
+		if cut == -1 {
+			searched = len(buf) - 1
+			continue
+		}
+		cut += searched + 1
+
+		next := append(getChunk(size), buf[cut:]...)
+		if !sendChunk(ctx, chunks, chunk{data: buf[:cut], src: src}) {
+			return nil
+		}
+		buf, searched = next, 0
+	}
+}
+
+// sendChunk sends c to the workers, and returns false if the run is
+// cancelled before
+func sendChunk(ctx context.Context, chunks chan<- chunk, c chunk) bool {
+	if c.src.window != nil {
+		select {
+		case c.src.window <- struct{}{}:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	atomic.AddInt64(&c.src.pending, 1)
+	c.index = c.src.sent
+	c.src.sent++
+	c.src.read += int64(len(c.data))
+	c.end = c.src.read
+	select {
+	case chunks <- c:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// parseChunk splits a chunk into records, appends the encoded records to
+// batch, and returns the nb of invalid characters replaced by 'N'
+func parseChunk(chunk []byte, batch []encodedSequence) ([]encodedSequence, int) {
+
+	invalid := 0
+	for {
+		// skip empty lines between records
+		chunk = bytes.TrimLeft(chunk, "\r\n")
+		if len(chunk) == 0 {
+			return batch, invalid
+		}
+		end := len(chunk)
+		if i := bytes.Index(chunk[1:], recordStart); i != -1 {
+			end = i + 2
+		}
+		sequence, n := newEncodedSequence(chunk[:end])
+		batch = append(batch, sequence)
+		invalid += n
+		chunk = chunk[end:]
+	}
+}
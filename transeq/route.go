@@ -0,0 +1,243 @@
+package transeq
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// recordSpan marks the end of a record in the buffer of a writer, with
+// the properties used to route it to an output file
+type recordSpan struct {
+	end        int
+	frameIndex int
+	// id of the input sequence, only set when routing by record
+	seqID string
+	// whether the beginning of the record has already been written
+	continued bool
+}
+
+// router dispatches the records written by the workers to several
+// output files, named after the --outseq file
+type router struct {
+	base, ext  string
+	byFrame    bool
+	byRecord   bool
+	maxRecords int
+	maxBytes   int64
+	pattern    string
+	frameNames [6]string
+	force      bool
+
+	// files currently open, by name. Only the files of the frames are
+	// kept open together
+	open map[string]*os.File
+	// names of the files created, in creation order, and their
+	// temporary file until the run is complete
+	files []string
+	temp  map[string]string
+
+	// current shard, and nb of records and bytes written to it
+	shard      int
+	shardCount int
+	shardBytes int64
+}
+
+func newRouter(outseq string, options Options) (*router, error) {
+
+	modes := 0
+	for _, enabled := range []bool{options.ByFrame, options.ByRecord, options.ShardRecords > 0 || options.ShardSize > 0} {
+		if enabled {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return nil, fmt.Errorf("--by-frame, --by-record and --shard-records or --shard-size can't be used together")
+	}
+	if options.ShardRecords < 0 {
+		return nil, fmt.Errorf("wrong value for --shard-records parameter: %d", options.ShardRecords)
+	}
+
+	ext := filepath.Ext(outseq)
+	r := &router{
+		base:       strings.TrimSuffix(outseq, ext),
+		ext:        ext,
+		byFrame:    options.ByFrame,
+		byRecord:   options.ByRecord,
+		maxRecords: options.ShardRecords,
+		maxBytes:   int64(options.ShardSize),
+		pattern:    options.ShardPattern,
+		frameNames: frameNames[withDefaults(options).FrameNames],
+		force:      options.Force,
+		open:       map[string]*os.File{},
+		temp:       map[string]string{},
+	}
+	if r.pattern == "" {
+		r.pattern = strings.Replace(r.base, "%", "%%", -1) + ".%03d" + strings.Replace(ext, "%", "%%", -1)
+	}
+	if name := fmt.Sprintf(r.pattern, 1); strings.Contains(name, "%!") || name == fmt.Sprintf(r.pattern, 2) {
+		return nil, fmt.Errorf("wrong value for --shard-pattern parameter: %s, expected a single %%d verb for the shard number", options.ShardPattern)
+	}
+	return r, nil
+}
+
+// write writes each record of buf to its output file
+func (r *router) write(buf []byte, spans []recordSpan) error {
+
+	start := 0
+	for _, s := range spans {
+		f, err := r.file(r.name(s, s.end-start))
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(buf[start:s.end])
+		if err != nil {
+			return err
+		}
+		start = s.end
+	}
+	return nil
+}
+
+// name returns the name of the output file of a record of size bytes
+func (r *router) name(s recordSpan, size int) string {
+
+	switch {
+	case r.byFrame:
+		return r.base + ".frame" + r.frameNames[s.frameIndex] + r.ext
+	case r.byRecord:
+		return r.base + "." + safeFileName(s.seqID) + r.ext
+	}
+
+	if !s.continued {
+		full := r.maxRecords > 0 && r.shardCount >= r.maxRecords ||
+			r.maxBytes > 0 && r.shardBytes > 0 && r.shardBytes+int64(size) > r.maxBytes
+		if r.shard == 0 || full {
+			r.shard++
+			r.shardCount, r.shardBytes = 0, 0
+		}
+		r.shardCount++
+	}
+	r.shardBytes += int64(size)
+	return fmt.Sprintf(r.pattern, r.shard)
+}
+
+// file returns the temporary file of the output file name. It's created
+// when first opened, and appended to afterwards
+func (r *router) file(name string) (*os.File, error) {
+
+	if f, ok := r.open[name]; ok {
+		return f, nil
+	}
+	if !r.byFrame {
+		// records of a shard or of an input record are written
+		// consecutively, so only the last file is kept open
+		err := r.close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var f *os.File
+	var err error
+	if temp, ok := r.temp[name]; ok {
+		f, err = os.OpenFile(temp, os.O_WRONLY|os.O_APPEND, 0644)
+	} else {
+		f, err = createTemp(name, r.force)
+		if err == nil {
+			r.temp[name] = f.Name()
+			r.files = append(r.files, name)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.open[name] = f
+	return f, nil
+}
+
+// commit closes the files, and renames them to their final name
+func (r *router) commit() error {
+	err := r.close()
+	if err != nil {
+		r.abort()
+		return err
+	}
+	for _, name := range r.files {
+		err = os.Rename(r.temp[name], name)
+		if err != nil {
+			r.abort()
+			return err
+		}
+		delete(r.temp, name)
+	}
+	return nil
+}
+
+// abort closes and removes the temporary files
+func (r *router) abort() {
+	r.close()
+	for _, temp := range r.temp {
+		os.Remove(temp)
+	}
+	r.files = nil
+}
+
+// close closes the files currently open
+func (r *router) close() error {
+	var err error
+	for name, f := range r.open {
+		if e := f.Close(); e != nil && err == nil {
+			err = e
+		}
+		delete(r.open, name)
+	}
+	return err
+}
+
+// safeFileName replaces the characters of a sequence id that can't be
+// used in a file name
+func safeFileName(id string) string {
+	if id == "" {
+		return "_"
+	}
+	return strings.Map(func(c rune) rune {
+		switch c {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|', 0:
+			return '_'
+		}
+		return c
+	}, id)
+}
+
+// TranslateToFiles translates the sequences of inputSequence like
+// Translate, and writes the records to outseq, or to several files named
+// after outseq as requested by Options.ByFrame, Options.ByRecord,
+// Options.ShardRecords and Options.ShardSize.
+//
+// Files are only renamed to their final name once the run is complete,
+// and are removed if it fails or if ctx is cancelled, unless
+// Options.Resume is set. In this case, the records are written in input
+// order, and the run can be resumed by calling TranslateToFiles again
+// with the same input and options. It returns the names of the files
+// written, in creation order
+func TranslateToFiles(ctx context.Context, inputSequence io.Reader, outseq string, options Options) ([]string, error) {
+
+	if options.Resume {
+		return translateResumable(ctx, inputSequence, outseq, options)
+	}
+
+	o, closeOutput, err := newFileOutput(outseq, options)
+	if err != nil {
+		return nil, err
+	}
+	err = translate(ctx, []*source{singleSource(inputSequence, o)}, inputSize(inputSequence), options)
+	files, closeErr := closeOutput(err == nil)
+	if err == nil {
+		err = closeErr
+	}
+	return files, err
+}
@@ -0,0 +1,218 @@
+package transeq
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// source is an input of a run, and the output its records are written to
+type source struct {
+	// nb of chunks of the source not processed yet, plus one until the
+	// whole source is read. int64 fields come first so they are 64-bit
+	// aligned for atomic operations on 32-bit platforms
+	pending int64
+	// nb of chunks and of bytes of the input sent to the workers
+	sent int64
+	read int64
+
+	// name of the input, added to the headers of the records if not
+	// empty
+	name string
+	// opens the input and the output of the source
+	open func() (io.ReadCloser, *output, error)
+	out  *output
+	// closes the output once all the records of the source are written,
+	// and renames its files to their final name if commit is set. nil if
+	// the output is shared with other sources
+	closeOutput func(commit bool) error
+	// names of the output files of the source, set when its output is
+	// closed
+	files []string
+	// if not nil, the records of the source are written in input order,
+	// and the progress of the run is saved to the checkpoint
+	checkpoint *checkpoint
+	// with a checkpoint, holds a slot for each chunk sent to the workers
+	// and not written yet, so the workers can't run ahead of the chunk
+	// waited for by more than the capacity of window
+	window chan struct{}
+}
+
+// release marks a chunk of the source, or the end of its input, as
+// processed. Once all the records of the source are written, its output
+// is closed, and committed unless the run has been cancelled
+func (s *source) release(commit bool) {
+	if atomic.AddInt64(&s.pending, -1) != 0 || s.closeOutput == nil {
+		return
+	}
+	err := s.closeOutput(commit)
+	if err != nil {
+		s.out.fail(fmt.Errorf("fail to close output file: %v", err))
+	}
+}
+
+// newFileOutput creates the output file name, or the files named after
+// it when the records are routed to several files. The returned function
+// closes the output and, if commit is set, renames the files to their
+// final name and returns them. Otherwise, the files are removed
+func newFileOutput(name string, options Options) (*output, func(commit bool) ([]string, error), error) {
+
+	if options.SplitsOutput() {
+		r, err := newRouter(name, options)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &output{router: r}, func(commit bool) ([]string, error) {
+			if !commit {
+				r.abort()
+				return nil, nil
+			}
+			err := r.commit()
+			return r.files, err
+		}, nil
+	}
+
+	f, err := CreateAtomic(name, options.Force)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &output{w: f}, func(commit bool) ([]string, error) {
+		if !commit {
+			return nil, f.Abort()
+		}
+		return []string{name}, f.Commit()
+	}, nil
+}
+
+// outputName returns the name of the output file of an input, where
+// {name} in template is replaced by the name of the input file without
+// its extension, and {dir} by its directory
+func outputName(template, input string) string {
+	base := filepath.Base(input)
+	return strings.NewReplacer(
+		"{name}", strings.TrimSuffix(base, filepath.Ext(base)),
+		"{dir}", filepath.Dir(input),
+	).Replace(template)
+}
+
+// TranslateFiles translates the fasta files inputs like Translate, with a
+// single pool of workers for all the files.
+//
+// If outTemplate is empty, the records of all the inputs are written to
+// outseq, and the name of their input file is added to their header as
+// 'source=<file>'. Otherwise, the records of each input are written to
+// its own file, named after outTemplate where {name} is replaced by the
+// name of the input file without its extension, and {dir} by its
+// directory. Options.ByFrame, Options.ByRecord and sharding apply to each
+// output file.
+//
+// Input files are opened one at a time, and per input outputs are
+// committed as soon as all their records are written. If the run fails or
+// ctx is cancelled, the outputs not complete yet are removed. It returns
+// the names of the files written
+func TranslateFiles(ctx context.Context, inputs []string, outseq, outTemplate string, options Options) ([]string, error) {
+
+	if options.Resume {
+		return nil, fmt.Errorf("--resume can only be used with a single input file and output file")
+	}
+	if len(inputs) > 1 && outTemplate != "" && !strings.Contains(outTemplate, "{name}") {
+		return nil, fmt.Errorf("wrong value for --output-template parameter: %s, expected {name} in the template to name the output of each input", outTemplate)
+	}
+
+	var size int64
+	outputs := map[string]string{}
+	for _, input := range inputs {
+
+		info, err := os.Stat(input)
+		if err != nil {
+			return nil, err
+		}
+		size += info.Size()
+
+		if outTemplate == "" {
+			continue
+		}
+		name := outputName(outTemplate, input)
+		if other, ok := outputs[name]; ok {
+			return nil, fmt.Errorf("inputs %s and %s have the same output file %s, use {dir} in --output-template to tell them apart", other, input, name)
+		}
+		outputs[name] = input
+		if _, err := os.Stat(name); err == nil && !options.Force && !options.SplitsOutput() {
+			// checked before the run, as outputs are only
+			// created once their input is read
+			return nil, fmt.Errorf("output file %s already exists, use --force to overwrite it", name)
+		}
+	}
+
+	var merged *output
+	var closeMerged func(commit bool) ([]string, error)
+	if outTemplate == "" {
+		var err error
+		merged, closeMerged, err = newFileOutput(outseq, options)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sources := make([]*source, len(inputs))
+	for i, input := range inputs {
+
+		src, input := &source{}, input
+		sources[i] = src
+		if merged != nil {
+			src.name = input
+			src.open = func() (io.ReadCloser, *output, error) {
+				f, err := os.Open(input)
+				return f, merged, err
+			}
+			continue
+		}
+		src.open = func() (io.ReadCloser, *output, error) {
+			f, err := os.Open(input)
+			if err != nil {
+				return nil, nil, err
+			}
+			o, closeOutput, err := newFileOutput(outputName(outTemplate, input), options)
+			if err != nil {
+				f.Close()
+				return nil, nil, err
+			}
+			src.closeOutput = func(commit bool) error {
+				files, err := closeOutput(commit)
+				src.files = files
+				return err
+			}
+			return f, o, nil
+		}
+	}
+
+	err := translate(ctx, sources, size, options)
+
+	var files []string
+	if closeMerged != nil {
+		var closeErr error
+		files, closeErr = closeMerged(err == nil)
+		if err == nil {
+			err = closeErr
+		}
+	}
+	for _, src := range sources {
+		files = append(files, src.files...)
+	}
+	return files, err
+}
+
+// singleSource returns the source of a run with a single input already
+// open
+func singleSource(in io.Reader, out *output) *source {
+	return &source{
+		open: func() (io.ReadCloser, *output, error) {
+			return ioutil.NopCloser(in), out, nil
+		},
+	}
+}
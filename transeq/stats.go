@@ -0,0 +1,250 @@
+package transeq
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// interval between two updates of the progress display
+const progressInterval = time.Second
+
+// Stats holds statistics on a run of Translate. Counters are updated
+// atomically while the input is processed
+type Stats struct {
+	// int64 fields come first so they are 64-bit aligned for atomic
+	// operations on 32-bit platforms
+	InputBytes   int64 `json:"input_bytes"`
+	RecordsIn    int64 `json:"records_in"`
+	RecordsOut   int64 `json:"records_out"`
+	Bases        int64 `json:"bases"`
+	InvalidChars int64 `json:"invalid_chars"`
+	StopCodons   int64 `json:"stop_codons"`
+	// peak memory used in bytes, only measured with Options.MaxMemory.
+	// It's also set by InferTable and Validate
+	PeakMemory int64 `json:"peak_memory"`
+	// nb of records written for each frame, by frame index
+	frameRecords [6]int64
+
+	// nb of records written for each frame, by frame name
+	Proteins       map[string]int64 `json:"proteins_per_frame"`
+	ElapsedSeconds float64          `json:"elapsed_seconds"`
+
+	start time.Time
+}
+
+// counters of a worker, added to the stats of the run once per chunk to
+// limit the nb of atomic operations
+type counters struct {
+	records      int64
+	recordsOut   int64
+	bases        int64
+	invalidChars int64
+	stopCodons   int64
+	frameRecords [6]int64
+}
+
+// add adds the counters of a worker to s, and resets them
+func (s *Stats) add(c *counters) {
+	atomic.AddInt64(&s.RecordsIn, c.records)
+	atomic.AddInt64(&s.RecordsOut, c.recordsOut)
+	atomic.AddInt64(&s.Bases, c.bases)
+	atomic.AddInt64(&s.InvalidChars, c.invalidChars)
+	atomic.AddInt64(&s.StopCodons, c.stopCodons)
+	for i, n := range c.frameRecords {
+		if n != 0 {
+			atomic.AddInt64(&s.frameRecords[i], n)
+		}
+	}
+	*c = counters{}
+}
+
+// finish computes the per-frame counts and the elapsed time once all
+// the records are processed
+func (s *Stats) finish(framesToGenerate [6]int, names [6]string) {
+	s.Proteins = map[string]int64{}
+	for i, n := range s.frameRecords {
+		if framesToGenerate[i] != 0 {
+			s.Proteins[names[i]] = n
+		}
+	}
+	s.ElapsedSeconds = time.Since(s.start).Seconds()
+}
+
+// WriteSummary writes the statistics of the run to out in a human
+// readable format
+func (s *Stats) WriteSummary(out io.Writer) error {
+
+	frames := make([]string, 0, len(s.Proteins))
+	for name := range s.Proteins {
+		frames = append(frames, name)
+	}
+	sort.Strings(frames)
+
+	_, err := fmt.Fprintf(out, "records in:         %d\nrecords out:        %d\nbases translated:   %d\ninvalid characters: %d\nstop codons:        %d\n",
+		s.RecordsIn, s.RecordsOut, s.Bases, s.InvalidChars, s.StopCodons)
+	if err != nil {
+		return err
+	}
+	for _, name := range frames {
+		_, err = fmt.Fprintf(out, "proteins frame %-4s %d\n", name+":", s.Proteins[name])
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(out, "elapsed:            %v\n", time.Duration(s.ElapsedSeconds*float64(time.Second)).Round(time.Millisecond))
+	return err
+}
+
+// WriteJSON writes the statistics of the run to out as a JSON object
+func (s *Stats) WriteJSON(out io.Writer) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(append(data, '\n'))
+	return err
+}
+
+// writeStats writes the summary of the run to stderr and to the JSON
+// file requested in options
+func writeStats(s *Stats, options Options) error {
+
+	if options.Summary {
+		err := s.WriteSummary(os.Stderr)
+		if err != nil {
+			return err
+		}
+	}
+	if options.SummaryJSON == "" {
+		return nil
+	}
+	f, err := os.Create(options.SummaryJSON)
+	if err != nil {
+		return err
+	}
+	err = s.WriteJSON(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// countingReader counts the bytes read from the input
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+// inputSize returns the size of the input if it's a regular file, or -1
+func inputSize(in io.Reader) int64 {
+	f, ok := in.(interface {
+		Stat() (os.FileInfo, error)
+	})
+	if !ok {
+		return -1
+	}
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return -1
+	}
+	return info.Size()
+}
+
+// progress periodically writes the nb of records and bases processed,
+// the throughput and, if the size of the input is known, the estimated
+// time left. On a terminal, the progress is written on a single line
+// updated in place, otherwise a line is written at each update
+type progress struct {
+	stats     *Stats
+	inputSize int64
+	out       io.Writer
+	terminal  bool
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+func startProgress(stats *Stats, inputSize int64, out io.Writer) *progress {
+
+	p := &progress{
+		stats:     stats,
+		inputSize: inputSize,
+		out:       out,
+		done:      make(chan struct{}),
+	}
+	if f, ok := out.(*os.File); ok {
+		info, err := f.Stat()
+		p.terminal = err == nil && info.Mode()&os.ModeCharDevice != 0
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.write()
+			case <-p.done:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// write writes the progress of the run to p.out, over the current line
+// on a terminal
+func (p *progress) write() {
+
+	read := atomic.LoadInt64(&p.stats.InputBytes)
+	elapsed := time.Since(p.stats.start)
+
+	line := make([]byte, 0, 128)
+	if p.terminal {
+		line = append(line, '\r')
+	}
+	line = strconv.AppendInt(line, atomic.LoadInt64(&p.stats.RecordsIn), 10)
+	line = append(line, " records, "...)
+	line = strconv.AppendInt(line, atomic.LoadInt64(&p.stats.Bases), 10)
+	line = append(line, " bases, "...)
+	line = strconv.AppendFloat(line, float64(read)/mb/elapsed.Seconds(), 'f', 1, 64)
+	line = append(line, " MB/s"...)
+	if p.inputSize > 0 && read > 0 {
+		left := time.Duration(float64(elapsed) * float64(p.inputSize-read) / float64(read))
+		line = append(line, ", "...)
+		line = strconv.AppendInt(line, 100*read/p.inputSize, 10)
+		line = append(line, "%, ETA "...)
+		line = append(line, left.Round(time.Second).String()...)
+	}
+	if p.terminal {
+		// clear the end of a previous longer line
+		line = append(line, "\x1b[K"...)
+	} else {
+		line = append(line, '\n')
+	}
+	p.out.Write(line)
+}
+
+// stop stops the progress display, and writes the final state of the run
+func (p *progress) stop() {
+	close(p.done)
+	p.wg.Wait()
+	p.write()
+	if p.terminal {
+		p.out.Write([]byte{'\n'})
+	}
+}
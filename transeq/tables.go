@@ -0,0 +1,171 @@
+package transeq
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/feliixx/gotranseq/ncbicode"
+)
+
+// codeTables holds the codon <-> AA arrays of all the NCBI codes that
+// may be used during a run. It's shared read-only between workers
+type codeTables struct {
+	defaultTable int
+	arrays       map[int]*[arrayCodeSize]byte
+	// NCBI code to use by sequence id
+	byID map[string]int
+	// whether to read the NCBI code from sequence headers
+	modifiers bool
+	// an invalid code in a header is reported only once per run
+	invalidCode sync.Once
+}
+
+func newCodeTables(options Options) (*codeTables, error) {
+
+	t := &codeTables{
+		defaultTable: int(options.Table),
+		arrays:       map[int]*[arrayCodeSize]byte{},
+		modifiers:    options.TableModifiers,
+	}
+
+	needed := []int{int(options.Table)}
+	if options.TableMap != "" {
+		byID, err := loadTableMap(options.TableMap)
+		if err != nil {
+			return nil, err
+		}
+		t.byID = byID
+		for _, code := range byID {
+			needed = append(needed, code)
+		}
+	}
+	if t.modifiers {
+		// any code may be used
+		needed = append(needed, ncbicode.TableCodes()...)
+	}
+
+	for _, code := range needed {
+		if _, ok := t.arrays[code]; ok {
+			continue
+		}
+		codes, err := createCodeArray(code, options)
+		if err != nil {
+			return nil, err
+		}
+		t.arrays[code] = &codes
+	}
+	return t, nil
+}
+
+// loadTableMap reads a tab separated file where each line looks
+// like
+//
+//	<sequenceID>	<code>
+//
+// where <code> is the id or an alias of the NCBI code, and returns the
+// NCBI code by sequence id
+func loadTableMap(filename string) (map[string]int, error) {
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	byID := map[string]int{}
+
+	scanner := bufio.NewScanner(f)
+	lineNb := 0
+	for scanner.Scan() {
+
+		lineNb++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid line %d in %s: expected 2 tab separated fields but got %d", lineNb, filename, len(fields))
+		}
+		t, err := ncbicode.LookupTable(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid line %d in %s: invalid table code: %s", lineNb, filename, fields[1])
+		}
+		byID[strings.TrimPrefix(fields[0], ">")] = t.ID
+	}
+	return byID, scanner.Err()
+}
+
+// normalizeTableCode returns the code used for the standard
+// code in this package if code is the NCBI number of the
+// standard code
+func normalizeTableCode(code int) int {
+	if code == ncbicode.StandardNCBI {
+		return ncbicode.Standard
+	}
+	return code
+}
+
+func (t *codeTables) perSequence() bool {
+	return t.byID != nil || t.modifiers
+}
+
+// forSequence returns the codon <-> AA array to use for a sequence
+func (t *codeTables) forSequence(seqHeader []byte) *[arrayCodeSize]byte {
+	return t.arrays[t.codeFor(seqHeader)]
+}
+
+// codeFor returns the NCBI code to use for a sequence
+func (t *codeTables) codeFor(seqHeader []byte) int {
+
+	if code, ok := t.byID[string(sequenceID(seqHeader))]; ok {
+		return code
+	}
+
+	if t.modifiers {
+		value, ok := headerModifier(seqHeader, "transl_table")
+		if !ok {
+			value, ok = headerModifier(seqHeader, "gcode")
+		}
+		if ok {
+			code, err := strconv.Atoi(string(value))
+			if _, found := t.arrays[normalizeTableCode(code)]; err == nil && found {
+				return normalizeTableCode(code)
+			}
+			t.invalidCode.Do(func() {
+				fmt.Fprintf(os.Stderr, "WARNING: invalid table code in sequence %s: '%s', using code %d, other invalid codes are not reported\n", string(sequenceID(seqHeader)), string(value), t.defaultTable)
+			})
+		}
+	}
+	return t.defaultTable
+}
+
+// TableContents returns the amino acid each codon is translated to, for
+// each NCBI code that may be used with options, including the changes
+// made by Options.Selenocysteine and Options.Pyrrolysine. With
+// Options.TableModifiers, all the codes may be used
+func TableContents(options Options) (map[int]map[string]string, error) {
+
+	t, err := newCodeTables(options)
+	if err != nil {
+		return nil, err
+	}
+	contents := make(map[int]map[string]string, len(t.arrays))
+	for code, array := range t.arrays {
+		codons := make(map[string]string, 64)
+		for _, n1 := range gridOrder {
+			for _, n2 := range gridOrder {
+				for _, n3 := range gridOrder {
+					index := codonIndex(letterCode[byte(n1)], letterCode[byte(n2)], letterCode[byte(n3)])
+					codons[string([]rune{n1, n2, n3})] = string(array[index])
+				}
+			}
+		}
+		contents[code] = codons
+	}
+	return contents, nil
+}
@@ -0,0 +1,145 @@
+package transeq
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/feliixx/gotranseq/ncbicode"
+)
+
+// order of the nucleotides in the NCBI grid
+const gridOrder = "TCAG"
+
+// three letter code of each amino acid, as displayed in the NCBI grid
+var aaNames = map[byte]string{
+	stop: "Ter",
+}
+
+func init() {
+	for name, aa := range aaAbbreviations {
+		if len(name) == 3 && aa != stop && aa != unknown {
+			aaNames[aa] = name
+		}
+	}
+}
+
+// tableView holds the properties of a NCBI code needed to display it
+type tableView struct {
+	ncbicode.Table
+	codons map[string]byte
+	starts map[string]bool
+}
+
+func newTableView(code int) (*tableView, error) {
+
+	t, err := ncbicode.GetTable(code)
+	if err != nil {
+		return nil, err
+	}
+	codons, err := ncbicode.LoadTableCode(code)
+	if err != nil {
+		return nil, err
+	}
+	v := &tableView{
+		Table:  t,
+		codons: codons,
+		starts: map[string]bool{},
+	}
+	for _, codon := range t.StartCodons {
+		v.starts[codon] = true
+	}
+	return v, nil
+}
+
+// translation returns the translation of a codon, for example 'L Leu i',
+// 'i' marking start codons
+func (v *tableView) translation(codon string) string {
+	aa := v.codons[codon]
+	start := ' '
+	if v.starts[codon] {
+		start = 'i'
+	}
+	return fmt.Sprintf("%c %s   %c", aa, aaNames[aa], start)
+}
+
+// WriteTableList writes the id, the aliases and the name of each
+// available NCBI code to out, one code per line
+func WriteTableList(out io.Writer) error {
+
+	wr := bufio.NewWriter(out)
+	for _, code := range ncbicode.TableCodes() {
+		t, err := ncbicode.GetTable(code)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(wr, "%2d  %-35s %s\n", code, strings.Join(t.Aliases, ", "), t.Name)
+	}
+	return wr.Flush()
+}
+
+// WriteTableGrid writes the NCBI code as the 4x16 grid of codons used on
+// the NCBI website, for example
+//
+//	TTT F Phe      TCT S Ser      TAT Y Tyr      TGT C Cys
+//	TTC F Phe      TCC S Ser      TAC Y Tyr      TGC C Cys
+//	TTA L Leu      TCA S Ser      TAA * Ter      TGA * Ter
+//	TTG L Leu   i  TCG S Ser      TAG * Ter      TGG W Trp
+//
+// where rows are ordered by first and third nucleotide, columns by second
+// nucleotide, and start codons are marked with 'i'
+func WriteTableGrid(out io.Writer, code int) error {
+
+	v, err := newTableView(code)
+	if err != nil {
+		return err
+	}
+
+	wr := bufio.NewWriter(out)
+	fmt.Fprintf(wr, "%d. %s\n", code, v.Name)
+	for _, n1 := range gridOrder {
+		wr.WriteByte('\n')
+		for _, n3 := range gridOrder {
+			cells := make([]string, 0, len(gridOrder))
+			for _, n2 := range gridOrder {
+				codon := string([]rune{n1, n2, n3})
+				cells = append(cells, codon+" "+v.translation(codon))
+			}
+			fmt.Fprintf(wr, "  %s\n", strings.TrimRight(strings.Join(cells, "  "), " "))
+		}
+	}
+	return wr.Flush()
+}
+
+// WriteTableDiff writes the codons that are translated differently, or
+// that are start codons in only one of the NCBI codes, one codon per line
+// with its translation in each code, for example
+//
+//	TGA  * Ter      W Trp
+func WriteTableDiff(out io.Writer, code1, code2 int) error {
+
+	v1, err := newTableView(code1)
+	if err != nil {
+		return err
+	}
+	v2, err := newTableView(code2)
+	if err != nil {
+		return err
+	}
+
+	wr := bufio.NewWriter(out)
+	fmt.Fprintf(wr, "--- %d. %s\n+++ %d. %s\n", code1, v1.Name, code2, v2.Name)
+	for _, n1 := range gridOrder {
+		for _, n2 := range gridOrder {
+			for _, n3 := range gridOrder {
+				codon := string([]rune{n1, n2, n3})
+				if v1.codons[codon] == v2.codons[codon] && v1.starts[codon] == v2.starts[codon] {
+					continue
+				}
+				fmt.Fprintf(wr, "%s  %s  %s\n", codon, v1.translation(codon), strings.TrimRight(v2.translation(codon), " "))
+			}
+		}
+	}
+	return wr.Flush()
+}
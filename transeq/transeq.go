@@ -1,12 +1,15 @@
 package transeq
 
 import (
-	"bufio"
-	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"math"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/feliixx/gotranseq/ncbicode"
 )
@@ -19,15 +22,33 @@ const (
 	tCode
 	gCode
 	uCode = tCode
+	// code of invalid characters during encoding
+	invalidCode = 1<<nuclBits - 1
+
+	// nucleotides are coded on 3 bits, so the index of a codon
+	// fits in 9 bits
+	nuclBits = 3
+	// Length of the array to store codon <-> AA correspondance. It's
+	// small enough to stay in L1 cache
+	arrayCodeSize = 1 << (3 * nuclBits)
+)
 
-	// Length of the array to store codon <-> AA correspondance
-	// uses gCode because it's the biggest uint8 of all codes
-	arrayCodeSize = (uint32(gCode) | uint32(gCode)<<8 | uint32(gCode)<<16) + 1
+// letterCode gives the code of each nucleotide
+var letterCode = map[byte]uint8{
+	'A': aCode,
+	'C': cCode,
+	'T': tCode,
+	'G': gCode,
+	'N': nCode,
+	'U': uCode,
+}
 
-	maxSeqLength = 100 * mb
-)
+// codonIndex returns the index of a codon in the codon <-> AA array
+func codonIndex(n1, n2, n3 uint8) uint32 {
+	return uint32(n1) | uint32(n2)<<nuclBits | uint32(n3)<<(2*nuclBits)
+}
 
-func createCodeArray(tableCode int, clean bool) ([arrayCodeSize]byte, error) {
+func createCodeArray(tableCode int, options Options) ([arrayCodeSize]byte, error) {
 
 	var codes [arrayCodeSize]byte
 	for i := range codes {
@@ -39,24 +60,22 @@ func createCodeArray(tableCode int, clean bool) ([arrayCodeSize]byte, error) {
 	if err != nil {
 		return codes, err
 	}
-	
-	letterCode := map[byte]uint8{
-		'A': aCode,
-		'C': cCode,
-		'T': tCode,
-		'G': gCode,
-		'N': nCode,
-		'U': uCode,
+	// recode stop codons only if they are actually stop
+	// codons in this table
+	if options.Selenocysteine && codeMap["TGA"] == stop {
+		codeMap["TGA"] = selenocysteine
+	}
+	if options.Pyrrolysine && codeMap["TAG"] == stop {
+		codeMap["TAG"] = pyrrolysine
 	}
 
 	for codon, aaCode := range codeMap {
 
-		if !(clean && aaCode == stop) {
+		if !(options.Clean && aaCode == stop) {
 			// codon is always a 3 char string, for example 'ACG'
 			// each  nucleotide of the codon is represented by an uint8
 			n1, n2, n3 := letterCode[codon[0]], letterCode[codon[1]], letterCode[codon[2]]
-			index := uint32(n1) | uint32(n2)<<8 | uint32(n3)<<16
-			codes[index] = aaCode
+			codes[codonIndex(n1, n2, n3)] = aaCode
 		}
 		// in some case, all codon for an AA will start with the same
 		// two nucleotide, for example:
@@ -75,16 +94,23 @@ func createCodeArray(tableCode int, clean bool) ([arrayCodeSize]byte, error) {
 	for twoLetterCodon, aaCodeArray := range twoLetterMap {
 
 		aaCode := aaCodeArray[0]
-		if len(aaCodeArray) == 1 && !(clean && aaCode == stop) {
+		if len(aaCodeArray) == 1 && !(options.Clean && aaCode == stop) {
 
 			n1, n2 := letterCode[twoLetterCodon[0]], letterCode[twoLetterCodon[1]]
-			index := uint32(n1) | uint32(n2)<<8
-			codes[index] = aaCode
+			codes[codonIndex(n1, n2, nCode)] = aaCode
 		}
 	}
 	return codes, nil
 }
 
+// naming conventions of the frames, indexed by frame index
+var frameNames = map[string][6]string{
+	// EMBOSS transeq
+	"emboss": {"1", "2", "3", "4", "5", "6"},
+	// BLASTX, DIAMOND
+	"signed": {"+1", "+2", "+3", "-1", "-2", "-3"},
+}
+
 func computeFrames(frameName string) (frames [6]int, reverse bool, err error) {
 
 	var frameMap = map[string]struct {
@@ -94,6 +120,9 @@ func computeFrames(frameName string) (frames [6]int, reverse bool, err error) {
 		"1":  {[6]int{1, 0, 0, 0, 0, 0}, false},
 		"2":  {[6]int{0, 1, 0, 0, 0, 0}, false},
 		"3":  {[6]int{0, 0, 1, 0, 0, 0}, false},
+		"+1": {[6]int{1, 0, 0, 0, 0, 0}, false},
+		"+2": {[6]int{0, 1, 0, 0, 0, 0}, false},
+		"+3": {[6]int{0, 0, 1, 0, 0, 0}, false},
 		"F":  {[6]int{1, 1, 1, 0, 0, 0}, false},
 		"-1": {[6]int{0, 0, 0, 1, 0, 0}, true},
 		"-2": {[6]int{0, 0, 0, 0, 1, 0}, true},
@@ -102,115 +131,233 @@ func computeFrames(frameName string) (frames [6]int, reverse bool, err error) {
 		"6":  {[6]int{1, 1, 1, 1, 1, 1}, true},
 	}
 
-	f, ok := frameMap[frameName]
-	if !ok {
-		return frames, false, fmt.Errorf("wrong value for -f | --frame parameter: %s", frameName)
+	// frameName is a comma separated list of frames, for
+	// example '1,-2'
+	for _, name := range strings.Split(frameName, ",") {
+
+		f, ok := frameMap[strings.TrimSpace(name)]
+		if !ok {
+			return frames, false, fmt.Errorf("wrong value for -f | --frame parameter: %s", frameName)
+		}
+		for i, generate := range f.frames {
+			if generate == 1 {
+				frames[i] = 1
+			}
+		}
+		reverse = reverse || f.reverse
 	}
-	return f.frames, f.reverse, nil
+	return frames, reverse, nil
 }
 
 // Translate read a fasta file and translate each sequence to the corresponding prot sequence
 // with the specified options
 func Translate(inputSequence io.Reader, out io.Writer, options Options) error {
+	return TranslateContext(context.Background(), inputSequence, out, options)
+}
+
+// TranslateContext is like Translate, but stops reading and translating
+// the sequences once ctx is cancelled, and returns ctx.Err() in this case.
+// The records written to out before the cancellation are complete, but
+// the output is not.
+//
+// All the goroutines started are done when TranslateContext returns.
+// A Read call on inputSequence or a Write call on out blocked when ctx is
+// cancelled can't be interrupted though, so TranslateContext returns once
+// it returns
+func TranslateContext(ctx context.Context, inputSequence io.Reader, out io.Writer, options Options) error {
+	return translate(ctx, []*source{singleSource(inputSequence, &output{w: out})}, inputSize(inputSequence), options)
+}
+
+// translate translates the sequences of each source with a single pool
+// of workers. size is the total size of the inputs, or -1 if unknown.
+// It returns ctx.Err() if ctx is cancelled before all the records are
+// written
+func translate(parent context.Context, sources []*source, size int64, options Options) error {
+
+	options = withDefaults(options)
 
 	framesToGenerate, reverse, err := computeFrames(options.Frame)
 	if err != nil {
 		return err
 	}
 
-	codes, err := createCodeArray(options.Table, options.Clean)
+	tables, err := newCodeTables(options)
 	if err != nil {
 		return err
 	}
 
-	fnaSequences := make(chan encodedSequence, 100)
-	errs := make(chan error, 1)
+	excepts, err := loadTranslExcept(options.TranslExcept)
+	if err != nil {
+		return err
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	if _, ok := frameNames[options.FrameNames]; !ok {
+		return fmt.Errorf("wrong value for --frame-names parameter: %s", options.FrameNames)
+	}
 
-	var wg sync.WaitGroup
-	wg.Add(options.NumWorker)
+	if len(options.StopChar) > 1 {
+		return fmt.Errorf("wrong value for --stop-char parameter: %s, expected a single character", options.StopChar)
+	}
 
-	for nWorker := 0; nWorker < options.NumWorker; nWorker++ {
+	switch options.LeadingPartial {
+	case partialUnknown, partialDrop:
+	default:
+		return fmt.Errorf("wrong value for --leading-partial parameter: %s", options.LeadingPartial)
+	}
 
-		go func() {
+	switch options.TrailingPartial {
+	case partialGuess, partialUnknown, partialDrop:
+	default:
+		return fmt.Errorf("wrong value for --trailing-partial parameter: %s", options.TrailingPartial)
+	}
 
-			defer wg.Done()
+	switch options.Best {
+	case "", bestScore, bestStretch, bestORF:
+	default:
+		return fmt.Errorf("wrong value for --best parameter: %s", options.Best)
+	}
 
-			w := newWriter(codes, framesToGenerate, reverse, options.Alternative, options.Trim)
+	p, err := newPipeline(parent, options)
+	if err != nil {
+		return err
+	}
+	defer p.stop()
 
-			for sequence := range fnaSequences {
+	stats := options.Stats
+	if stats == nil {
+		stats = &Stats{}
+	}
+	stats.start = time.Now()
+	var prog *progress
+	if options.Progress {
+		prog = startProgress(stats, size, os.Stderr)
+	}
 
-				select {
-				case <-ctx.Done():
-					return
-				default:
-				}
+	// translations of the chunks of a resumable run, written in input
+	// order by writeOrdered
+	var results chan chunkOutput
+	var ordered sync.WaitGroup
+	if sources[0].checkpoint != nil {
+		results = make(chan chunkOutput, options.NumWorker)
+	}
 
-				w.translate(sequence)
+	p.start(options.NumWorker, func() worker {
 
-				if len(w.buf) > maxBufferSize {
-					w.flush(out, cancel, errs)
+		w := newWriter(tables, framesToGenerate, reverse, excepts, options, nil)
+
+		return worker{
+			startChunk: func(c chunk, invalid int) {
+				if c.src.out != w.out {
+					if w.out != nil {
+						w.flush()
+					}
+					w.out = c.src.out
 				}
-				pool.Put(sequence)
-			}
-			w.flush(out, cancel, errs)
-		}()
-	}
-	readSequenceFromFasta(ctx, inputSequence, fnaSequences)
+				w.source = c.src.name
+				w.counts.invalidChars += int64(invalid)
+			},
+			sequence: func(sequence encodedSequence) {
+				w.translate(sequence)
 
-	wg.Wait()
+				if len(w.buf) > w.out.bufferSize {
+					w.flush()
+				}
+			},
+			endChunk: func(c chunk) bool {
+				stats.add(&w.counts)
+
+				if results != nil {
+					select {
+					case results <- chunkOutput{index: c.index, end: c.end, buf: w.buf}:
+					case <-p.ctx.Done():
+						return false
+					}
+					w.buf = nil
+				}
 
-	select {
-	case err, ok := <-errs:
-		if ok {
-			return err
+				if c.src.closeOutput != nil {
+					// the output of the source is closed once all
+					// its chunks are written
+					w.flush()
+					c.src.release(p.ctx.Err() == nil)
+				}
+				return true
+			},
+			done: func() {
+				if w.out != nil {
+					w.flush()
+				}
+			},
 		}
-	default:
-	}
-	return nil
-}
-
-// fasta format is:
-//
-// >sequenceID some comments on sequence
-// ACAGGCAGAGACACGACAGACGACGACACAGGAGCAGACAGCAGCAGACGACCACATATT
-// TTTGCGGTCACATGACGACTTCGGCAGCGA
-//
-// see https://blast.ncbi.nlm.nih.gov/Blast.cgi?CMD=Web&PAGE_TYPE=BlastDocs&DOC_TYPE=BlastHelp
-// section 1 for details
-func readSequenceFromFasta(ctx context.Context, inputSequence io.Reader, fnaSequences chan<- encodedSequence) {
+	})
 
-	scanner := bufio.NewScanner(inputSequence)
-	scanner.Buffer(make([]byte, 0, 4096), maxSeqLength)
+	var readErr error
+	for _, src := range sources {
 
-	buf := bytes.NewBuffer(make([]byte, 0, 4096))
-	headerSize := 0
-
-Loop:
-	for scanner.Scan() {
+		if p.ctx.Err() != nil {
+			break
+		}
+		var in io.ReadCloser
+		in, src.out, readErr = src.open()
+		if readErr != nil {
+			break
+		}
+		if src.out.errs == nil {
+			// outputs may be shared between sources
+			p.setOutput(src.out)
+		}
+		src.pending = 1
+
+		if src.checkpoint != nil {
+			// records are only written once their whole chunk is
+			// translated, so the workers never flush them
+			src.out.bufferSize = math.MaxInt32
+			src.window = make(chan struct{}, options.NumWorker)
+			ordered.Add(1)
+			go func(src *source) {
+				defer ordered.Done()
+				writeOrdered(results, src)
+			}(src)
+		}
 
-		line := scanner.Bytes()
-		if len(line) == 0 {
-			continue
+		readErr = p.read(countingReader{r: in, n: &stats.InputBytes}, src)
+		in.Close()
+		if readErr != nil || p.ctx.Err() != nil {
+			// the output of the source is removed once the
+			// workers are done
+			break
 		}
-		if line[0] == '>' {
-			if buf.Len() > 0 {
-				select {
-				case <-ctx.Done():
-					break Loop
-				default:
-				}
-				fnaSequences <- newEncodedSequence(buf, headerSize)
-			}
-			buf.Reset()
-			headerSize = len(line)
+		src.release(true)
+	}
+
+	err = p.wait()
+	if results != nil {
+		close(results)
+		ordered.Wait()
+	}
+	stats.PeakMemory = p.stop()
+	if prog != nil {
+		prog.stop()
+	}
+	for _, src := range sources {
+		if src.closeOutput != nil && atomic.LoadInt64(&src.pending) > 0 {
+			// the run has been cancelled before all the records
+			// of the source were written
+			src.closeOutput(false)
 		}
-		buf.Write(line)
 	}
 
-	fnaSequences <- newEncodedSequence(buf, headerSize)
+	if parent.Err() != nil {
+		// errors caused by the cancellation are not reported
+		return parent.Err()
+	}
+	if err != nil {
+		return err
+	}
+	if readErr != nil {
+		return fmt.Errorf("fail to read input file: %v", readErr)
+	}
 
-	close(fnaSequences)
+	stats.finish(framesToGenerate, frameNames[options.FrameNames])
+	return writeStats(stats, options)
 }
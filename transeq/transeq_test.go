@@ -2,10 +2,18 @@ package transeq_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"math/rand"
+	"os"
+	"runtime"
+	"runtime/debug"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/feliixx/gotranseq/transeq"
 	"github.com/jessevdk/go-flags"
@@ -74,3 +82,1585 @@ func getOptionsAndName(opts string) (options transeq.Options, err error) {
 
 	return options, err
 }
+
+func translate(t *testing.T, input string, opts string) string {
+
+	options, err := getOptionsAndName(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.NumWorker = 1
+
+	out := bytes.NewBuffer(nil)
+	err = transeq.Translate(strings.NewReader(input), out, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestFilters(t *testing.T) {
+
+	input := ">s1 comment\nATGAAAACCGCGTATATTGCGAAACAGCGCCAG\n"
+
+	tests := []struct {
+		name     string
+		options  string
+		expected string
+	}{
+		{
+			name:     "no filter",
+			options:  "-frame=F",
+			expected: ">s1_1 comment\nMKTAYIAKQRQ\n>s1_2 comment\n*KPRILRNSAX\n>s1_3 comment\nENRVYCETAPX\n",
+		},
+		{
+			name:     "max stops",
+			options:  "-frame=F -max-stops=0",
+			expected: ">s1_1 comment\nMKTAYIAKQRQ\n>s1_3 comment\nENRVYCETAPX\n",
+		},
+		{
+			name:     "max x",
+			options:  "-frame=F -max-x=0.05",
+			expected: ">s1_1 comment\nMKTAYIAKQRQ\n",
+		},
+		{
+			name:     "min length",
+			options:  "-frame=F -trim -min-length=11",
+			expected: ">s1_1 comment\nMKTAYIAKQRQ\n",
+		},
+		{
+			name:     "best",
+			options:  "-frame=6 -best",
+			expected: ">s1_1 frame=1 comment\nMKTAYIAKQRQ\n",
+		},
+		{
+			name:     "best with filter",
+			options:  "-frame=F -best -min-length=12",
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			if want, got := test.expected, translate(t, input, test.options); want != got {
+				t.Errorf("expected\n%s\nbut got\n%s", want, got)
+			}
+		})
+	}
+}
+
+func TestBestFrame(t *testing.T) {
+
+	input := ">s1 comment\nATGAAATAGCGCGCGTATTGATTGCGAAACAGCGCCAGT\n>s2\nATGTAACGCGCGTAGCCATGGCAGGC\n"
+
+	tests := []struct {
+		name     string
+		input    string
+		options  string
+		expected string
+	}{
+		{
+			name:     "longest stretch",
+			options:  "-frame=6 -best=stretch",
+			expected: ">s1_3 frame=3 stretch=1-13 comment\nEIARVLIAKQRQX\n>s2_2 frame=2 stretch=1-9\nCNARSHGRX\n",
+		},
+		{
+			name:     "longest orf",
+			options:  "-frame=6 -best=orf",
+			expected: ">s1_1 frame=1 orf=1-2 comment\nMK*RAY*LRNSAS\n>s2_5 frame=5 orf=3-9\nPAMATRVTX\n",
+		},
+		{
+			name:     "longest orf forward only",
+			options:  "-frame=F -best=orf -trim",
+			expected: ">s1_1 frame=1 orf=1-2 comment\nMK*RAY*LRNSAS\n>s2_3 frame=3 orf=6-8\nVTRVAMAG\n",
+		},
+		{
+			name:     "no orf",
+			input:    ">s1\nCCCTAACCC\n>s2\nATGCCC\n",
+			options:  "-frame=1 -best=orf",
+			expected: ">s2_1 frame=1 orf=1-2\nMP\n",
+		},
+	}
+
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			input := input
+			if test.input != "" {
+				input = test.input
+			}
+			if want, got := test.expected, translate(t, input, test.options); want != got {
+				t.Errorf("expected\n%s\nbut got\n%s", want, got)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+
+	input := ">s1 comment\nATGAAATAGCGCGCGTATTGATTGCGAAACAGCGCCAGT\n>s2\nATGTAACGCGCGTAG\n"
+
+	tests := []struct {
+		name     string
+		options  string
+		expected string
+	}{
+		{
+			name:     "forward frame",
+			options:  "-frame=1 -split",
+			expected: ">s1_1_1-2 nt=1-6 comment\nMK\n>s1_1_4-6 nt=10-18 comment\nRAY\n>s1_1_8-13 nt=22-39 comment\nLRNSAS\n>s2_1_1-1 nt=1-3\nM\n>s2_1_3-4 nt=7-12\nRA\n",
+		},
+		{
+			name:     "reverse frames",
+			options:  "-frame=-2 -split",
+			expected: ">s1_5_1-13 nt=37-1 comment\nWRCFAINTRAISX\n>s2_5_1-5 nt=13-1\nTRVTX\n",
+		},
+		{
+			name:     "best frame",
+			options:  "-frame=6 -best=stretch -split",
+			expected: ">s1_3_1-13 nt=3-39 frame=3 stretch=1-13 comment\nEIARVLIAKQRQX\n>s2_2_1-5 nt=2-15 frame=2 stretch=1-5\nCNARX\n",
+		},
+		{
+			name:     "min fragment",
+			options:  "-frame=1 -split -min-fragment=3",
+			expected: ">s1_1_4-6 nt=10-18 comment\nRAY\n>s1_1_8-13 nt=22-39 comment\nLRNSAS\n",
+		},
+	}
+
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			if want, got := test.expected, translate(t, input, test.options); want != got {
+				t.Errorf("expected\n%s\nbut got\n%s", want, got)
+			}
+		})
+	}
+}
+
+func TestCoords(t *testing.T) {
+
+	input := ">s1 comment\nATGAAATAGCGCGCGTATTGATTGCGAAACAGCGCCAGT\n"
+
+	tests := []struct {
+		name     string
+		options  string
+		expected string
+	}{
+		{
+			name:     "six frames",
+			options:  "-frame=6 -coords",
+			expected: ">s1_1 strand=+ offset=0 nt=1-39 comment\nMK*RAY*LRNSAS\n>s1_2 strand=+ offset=1 nt=2-39 comment\n*NSARIDCETAPV\n>s1_3 strand=+ offset=2 nt=3-39 comment\nEIARVLIAKQRQX\n>s1_4 strand=- offset=0 nt=39-1 comment\nTGAVSQSIRALFH\n>s1_5 strand=- offset=2 nt=37-1 comment\nWRCFAINTRAISX\n>s1_6 strand=- offset=1 nt=38-1 comment\nLALFRNQYARYFX\n",
+		},
+		{
+			name:     "alternative",
+			options:  "-frame=R -coords -alternative",
+			expected: ">s1_4 strand=- offset=0 nt=39-1 comment\nTGAVSQSIRALFH\n>s1_5 strand=- offset=1 nt=38-1 comment\nLALFRNQYARYFX\n>s1_6 strand=- offset=2 nt=37-1 comment\nWRCFAINTRAISX\n",
+		},
+		{
+			name:     "split",
+			options:  "-frame=1 -coords -split -min-fragment=3",
+			expected: ">s1_1_4-6 strand=+ offset=0 nt=10-18 comment\nRAY\n>s1_1_8-13 strand=+ offset=0 nt=22-39 comment\nLRNSAS\n",
+		},
+	}
+
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			if want, got := test.expected, translate(t, input, test.options); want != got {
+				t.Errorf("expected\n%s\nbut got\n%s", want, got)
+			}
+		})
+	}
+}
+
+func TestFrames(t *testing.T) {
+
+	input := ">s1 comment\nATGAAATAGCGCGCGTATTGATTGCGAAACAGCGCCAGT\n"
+
+	tests := []struct {
+		name     string
+		options  string
+		expected string
+	}{
+		{
+			name:     "frame list",
+			options:  "-frame=1,-2",
+			expected: ">s1_1 comment\nMK*RAY*LRNSAS\n>s1_5 comment\nWRCFAINTRAISX\n",
+		},
+		{
+			name:     "signed names",
+			options:  "-frame=+3,R -frame-names=signed",
+			expected: ">s1_+3 comment\nEIARVLIAKQRQX\n>s1_-1 comment\nTGAVSQSIRALFH\n>s1_-2 comment\nLALFRNQYARYFX\n>s1_-3 comment\nWRCFAINTRAISX\n",
+		},
+		{
+			name:     "signed names use alternative offsets",
+			options:  "-frame=R -frame-names=signed -alternative",
+			expected: ">s1_-1 comment\nTGAVSQSIRALFH\n>s1_-2 comment\nLALFRNQYARYFX\n>s1_-3 comment\nWRCFAINTRAISX\n",
+		},
+		{
+			name:     "signed names with best frame",
+			options:  "-frame=-1,-2 -frame-names=signed -best=stretch",
+			expected: ">s1_-1 frame=-1 stretch=1-13 comment\nTGAVSQSIRALFH\n",
+		},
+	}
+
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			if want, got := test.expected, translate(t, input, test.options); want != got {
+				t.Errorf("expected\n%s\nbut got\n%s", want, got)
+			}
+		})
+	}
+
+	for _, opts := range []string{"-frame=1,", "-frame=4", "-frame-names=blast"} {
+		options, err := getOptionsAndName(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		options.NumWorker = 1
+		err = transeq.Translate(strings.NewReader(input), bytes.NewBuffer(nil), options)
+		if err == nil {
+			t.Errorf("expected an error for options %s", opts)
+		}
+	}
+}
+
+func TestRecoding(t *testing.T) {
+
+	input := ">s1 comment\nATGTGATAGAAATGACTATTA\n>s2\nATGTGA\n"
+
+	f, err := ioutil.TempFile("", "transl_except")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("s1\t(pos:4..6,aa:Sec)\ns1\t(pos:complement(16..18),aa:Pyl)\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	tests := []struct {
+		name     string
+		options  string
+		expected string
+	}{
+		{
+			name:     "selenocysteine",
+			options:  "-frame=1 -selenocysteine",
+			expected: ">s1_1 comment\nMU*KULL\n>s2_1\nMU\n",
+		},
+		{
+			name:     "selenocysteine and pyrrolysine",
+			options:  "-frame=1 -selenocysteine -pyrrolysine",
+			expected: ">s1_1 comment\nMUOKULL\n>s2_1\nMU\n",
+		},
+		{
+			name:     "TGA is not a stop codon",
+			options:  "-frame=1 -selenocysteine -table=2",
+			expected: ">s1_1 comment\nMW*KWLL\n>s2_1\nMW\n",
+		},
+		{
+			name:     "transl_except",
+			options:  "-frame=1,-1 -transl-except=" + f.Name(),
+			expected: ">s1_1 comment\nMU*K*LL\n>s1_4 comment\n*OSFLSH\n>s2_1\nM*\n>s2_4\nSH\n",
+		},
+	}
+
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			if want, got := test.expected, translate(t, input, test.options); want != got {
+				t.Errorf("expected\n%s\nbut got\n%s", want, got)
+			}
+		})
+	}
+}
+
+func TestCompleteStop(t *testing.T) {
+
+	input := ">s1\nATGATATA\n>s2\nATGATAT\n>s3\nATGATAGT\n"
+
+	f, err := ioutil.TempFile("", "transl_except")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("s1\t(pos:7..8,aa:TERM)\ns2\t(pos:7,aa:TERM)\ns2\t(pos:complement(6),aa:TERM)\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	tests := []struct {
+		name     string
+		options  string
+		expected string
+	}{
+		{
+			name:     "default",
+			options:  "-frame=1 -table=2",
+			expected: ">s1_1\nMMX\n>s2_1\nMMX\n>s3_1\nMMV\n",
+		},
+		{
+			name:     "complete stop",
+			options:  "-frame=1 -table=2 -complete-stop",
+			expected: ">s1_1\nMM*\n>s2_1\nMM*\n>s3_1\nMMV\n",
+		},
+		{
+			name:     "complete stop with transl_except",
+			options:  "-frame=1 -table=2 -transl-except=" + f.Name(),
+			expected: ">s1_1\nMM*\n>s2_1\nMM*\n>s3_1\nMMV\n",
+		},
+		{
+			name:     "transl_except of a single nucleotide on the reverse strand",
+			options:  "-frame=-1 -table=2 -transl-except=" + f.Name(),
+			expected: ">s1_4\nYH\n>s2_4\n*H\n>s3_4\nYH\n",
+		},
+	}
+
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			if want, got := test.expected, translate(t, input, test.options); want != got {
+				t.Errorf("expected\n%s\nbut got\n%s", want, got)
+			}
+		})
+	}
+}
+
+func TestPartialCodons(t *testing.T) {
+
+	input := ">s1\nGCTGCAATGGT\n"
+
+	tests := []struct {
+		name     string
+		options  string
+		expected string
+	}{
+		{
+			name:     "default",
+			options:  "-frame=F",
+			expected: ">s1_1\nAAMV\n>s1_2\nLQWX\n>s1_3\nCNG\n",
+		},
+		{
+			name:     "trailing x",
+			options:  "-frame=F -trailing-partial=x",
+			expected: ">s1_1\nAAMX\n>s1_2\nLQWX\n>s1_3\nCNG\n",
+		},
+		{
+			name:     "trailing drop",
+			options:  "-frame=F -trailing-partial=drop",
+			expected: ">s1_1\nAAM\n>s1_2\nLQW\n>s1_3\nCNG\n",
+		},
+		{
+			name:     "leading x",
+			options:  "-frame=F -leading-partial=x -trailing-partial=drop -coords",
+			expected: ">s1_1 strand=+ offset=0 nt=1-9\nAAM\n>s1_2 strand=+ offset=-2 nt=1-10\nXLQW\n>s1_3 strand=+ offset=-1 nt=1-11\nXCNG\n",
+		},
+	}
+
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			if want, got := test.expected, translate(t, input, test.options); want != got {
+				t.Errorf("expected\n%s\nbut got\n%s", want, got)
+			}
+		})
+	}
+}
+
+func TestTrimming(t *testing.T) {
+
+	input := ">s1 c\nNNNATGTGAAAATAA\n"
+
+	tests := []struct {
+		name     string
+		options  string
+		expected string
+	}{
+		{
+			name:     "default",
+			options:  "-frame=1",
+			expected: ">s1_1 c\nXM*K*\n",
+		},
+		{
+			name:     "trim stop",
+			options:  "-frame=1 -trim-stop",
+			expected: ">s1_1 c\nXM*K\n",
+		},
+		{
+			name:     "trim leading x",
+			options:  "-frame=1 -trim-leading-x -coords",
+			expected: ">s1_1 strand=+ offset=3 nt=4-15 c\nM*K*\n",
+		},
+		{
+			name:     "stop char",
+			options:  "-frame=1 -stop-char=.",
+			expected: ">s1_1 c\nXM.K*\n",
+		},
+		{
+			name:     "stop char with trim",
+			options:  "-frame=1 -trim -stop-char=-",
+			expected: ">s1_1 c\nXM-K\n",
+		},
+		{
+			name:     "report stop",
+			options:  "-frame=1 -report-stop -trim-stop",
+			expected: ">s1_1 stop=yes c\nXM*K\n",
+		},
+	}
+
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			if want, got := test.expected, translate(t, input, test.options); want != got {
+				t.Errorf("expected\n%s\nbut got\n%s", want, got)
+			}
+		})
+	}
+}
+
+func TestCircular(t *testing.T) {
+
+	input := ">p1 [topology=circular]\nAAATAAATGCCC\n>p2\nCATTTATTTGGG\n>p3 [topology=linear]\nAAATAAATGCCC\n"
+
+	tests := []struct {
+		name     string
+		input    string
+		options  string
+		expected string
+	}{
+		{
+			name:     "header modifier",
+			options:  "-frame=1",
+			expected: ">p1_1 [topology=circular]\nK*MPK*\n>p2_1\nHLFG\n>p3_1 [topology=linear]\nK*MP\n",
+		},
+		{
+			name:    "circular with coordinates",
+			options: "-frame=1 -circular -split -coords",
+			expected: ">p1_1_1-1 strand=+ offset=0 nt=1-3 [topology=circular]\nK\n>p1_1_3-5 strand=+ offset=0 nt=7-3 [topology=circular]\nMPK\n" +
+				">p2_1_1-4 strand=+ offset=0 nt=1-12\nHLFG\n" +
+				">p3_1_1-1 strand=+ offset=0 nt=1-3 [topology=linear]\nK\n>p3_1_3-4 strand=+ offset=0 nt=7-12 [topology=linear]\nMP\n",
+		},
+		{
+			name:    "reverse frame crossing the origin",
+			options: "-frame=-1 -circular -split -coords",
+			expected: ">p1_4_1-4 strand=- offset=0 nt=12-1 [topology=circular]\nGHLF\n" +
+				">p2_4_1-2 strand=- offset=0 nt=12-7\nPK\n>p2_4_4-6 strand=- offset=0 nt=3-7\nMPK\n" +
+				">p3_4_1-4 strand=- offset=0 nt=12-1 [topology=linear]\nGHLF\n",
+		},
+		{
+			name:     "length not a multiple of 3 without stop codon",
+			input:    ">c1\nATGCCCAAAGGGCC\n",
+			options:  "-frame=F -circular -split -coords",
+			expected: ">c1_1_1-5 strand=+ offset=0 nt=1-1\nMPKGP\n>c1_2_1-5 strand=+ offset=1 nt=2-2\nCPKGH\n>c1_3_1-5 strand=+ offset=2 nt=3-3\nAQRAM\n",
+		},
+	}
+
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			in := input
+			if test.input != "" {
+				in = test.input
+			}
+			if want, got := test.expected, translate(t, in, test.options); want != got {
+				t.Errorf("expected\n%s\nbut got\n%s", want, got)
+			}
+		})
+	}
+}
+
+func TestLargeInput(t *testing.T) {
+
+	// records spanning several chunks of input, including a record bigger
+	// than a chunk, with lowercase letters and windows line endings
+	in := bytes.NewBuffer(nil)
+	expected := make(map[string]string, 2000)
+	for i := 0; i < 2000; i++ {
+		nbCodons := 1 + 37*i%5000
+		if i == 1000 {
+			nbCodons = 3000000
+		}
+		codon, eol := "ATG", "\n"
+		if i%2 == 0 {
+			codon, eol = "atg", "\r\n"
+		}
+		fmt.Fprintf(in, ">s%d comment%s", i, eol)
+		seq := strings.Repeat(codon, nbCodons)
+		for len(seq) > 60 {
+			in.WriteString(seq[:60] + eol)
+			seq = seq[60:]
+		}
+		in.WriteString(seq + eol)
+		expected[fmt.Sprintf("s%d_1 comment", i)] = strings.Repeat("M", nbCodons)
+	}
+
+	input := in.Bytes()
+
+	for _, opts := range []string{"-frame=1", "-frame=1 -max-memory=128M"} {
+
+		options, err := getOptionsAndName(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		options.NumWorker = 4
+
+		out := bytes.NewBuffer(nil)
+		err = transeq.Translate(bytes.NewReader(input), out, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		records := strings.Split(out.String(), ">")[1:]
+		if len(records) != len(expected) {
+			t.Errorf("%s: expected %d records but got %d", opts, len(expected), len(records))
+		}
+		for _, record := range records {
+			lines := strings.SplitN(record, "\n", 2)
+			if want, got := expected[lines[0]], strings.Replace(lines[1], "\n", "", -1); want != got {
+				t.Errorf("%s: wrong translation for %s: expected %d amino acids but got %d", opts, lines[0], len(want), len(got))
+			}
+		}
+	}
+}
+
+func TestMaxMemory(t *testing.T) {
+
+	tests := []struct {
+		value    string
+		expected transeq.ByteSize
+	}{
+		{value: "1048576", expected: 1 << 20},
+		{value: "512K", expected: 512 << 10},
+		{value: "64m", expected: 64 << 20},
+		{value: "2GB", expected: 2 << 30},
+	}
+	for _, test := range tests {
+		var size transeq.ByteSize
+		if err := size.UnmarshalFlag(test.value); err != nil {
+			t.Errorf("%s: %v", test.value, err)
+		}
+		if size != test.expected {
+			t.Errorf("%s: expected %d but got %d", test.value, test.expected, size)
+		}
+	}
+
+	var size transeq.ByteSize
+	if err := size.UnmarshalFlag("lots"); err == nil {
+		t.Error("expected an error for an invalid size")
+	}
+
+	options, err := getOptionsAndName("-frame=1 -max-memory=1M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.NumWorker = 4
+	err = transeq.Translate(strings.NewReader(">s1\nATG\n"), ioutil.Discard, options)
+	if err == nil || !strings.Contains(err.Error(), "too small") {
+		t.Errorf("expected an error for a budget too small, got %v", err)
+	}
+
+	options, err = getOptionsAndName("-frame=1 -max-memory=4M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.NumWorker = 4
+	options.Stats = &transeq.Stats{}
+	large := ">big\n" + strings.Repeat("ATG", 100000) + "\n"
+	err = transeq.Translate(strings.NewReader(large), ioutil.Discard, options)
+	if err != nil {
+		t.Errorf("expected a record of %d bytes to fit in the budget, got %v", len(large), err)
+	}
+	if options.Stats.PeakMemory == 0 {
+		t.Error("expected the peak memory to be measured")
+	}
+	large = ">big\n" + strings.Repeat("ATG", 200000) + "\n"
+	err = transeq.Translate(strings.NewReader(large), ioutil.Discard, options)
+	if err == nil || !strings.Contains(err.Error(), "largest record allowed by --max-memory") {
+		t.Errorf("expected an error for a record bigger than the budget, got %v", err)
+	}
+
+	// the six frames of a record close to the largest size allowed are
+	// translated within the budget
+	options, err = getOptionsAndName("-frame=6 -max-memory=128M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.NumWorker = 4
+	options.Stats = &transeq.Stats{}
+	large = ">big\n" + strings.Repeat("ATGCGTAAC", 1700000) + "\n"
+	// memory left from the previous tests is not counted
+	debug.FreeOSMemory()
+	err = transeq.Translate(strings.NewReader(large), ioutil.Discard, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peak := transeq.ByteSize(options.Stats.PeakMemory); peak > options.MaxMemory {
+		t.Errorf("expected a peak memory under %v but got %v", options.MaxMemory, peak)
+	}
+}
+
+func TestStats(t *testing.T) {
+
+	input := ">s1 comment\nATGAAATAGCGCGCGTATTGATTGCGAAACAGCGCCAGT\n>s2\nATGTAXCGCGCGTAG\n"
+
+	options, err := getOptionsAndName("-frame=-1,1 -frame-names=signed -max-stops=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.NumWorker = 2
+	options.Stats = &transeq.Stats{}
+
+	err = transeq.Translate(strings.NewReader(input), ioutil.Discard, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := options.Stats
+	if s.InputBytes != int64(len(input)) {
+		t.Errorf("expected %d input bytes but got %d", len(input), s.InputBytes)
+	}
+	if s.RecordsIn != 2 || s.RecordsOut != 3 || s.Bases != 54 || s.InvalidChars != 1 || s.StopCodons != 3 {
+		t.Errorf("wrong counters: %+v", *s)
+	}
+	if want, got := map[string]int64{"+1": 1, "-1": 2}, s.Proteins; len(got) != 2 || want["+1"] != got["+1"] || want["-1"] != got["-1"] {
+		t.Errorf("expected proteins per frame %v but got %v", want, got)
+	}
+
+	out := bytes.NewBuffer(nil)
+	err = s.WriteJSON(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := `"proteins_per_frame": {`, out.String(); !strings.Contains(got, want) {
+		t.Errorf("expected %s in\n%s", want, got)
+	}
+}
+
+func TestOutputRouting(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gotranseq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := ">s1 comment\nATGAAATAGCGC\n>s2\nATGTAACGC\n>s3\nATGGCA\n"
+	large := ">big\n" + strings.Repeat("ATG", 300000) + "\n" + input
+
+	tests := []struct {
+		name     string
+		input    string
+		options  string
+		expected map[string]string
+	}{
+		{
+			name:    "by frame",
+			input:   input,
+			options: "-frame=1,-1 -frame-names=signed -by-frame",
+			expected: map[string]string{
+				"out.frame+1.faa": ">s1_+1 comment\nMK*R\n>s2_+1\nM*R\n>s3_+1\nMA\n",
+				"out.frame-1.faa": ">s1_-1 comment\nALFH\n>s2_-1\nALH\n>s3_-1\nCH\n",
+			},
+		},
+		{
+			name:    "by record",
+			input:   input,
+			options: "-frame=1 -by-record",
+			expected: map[string]string{
+				"out.s1.faa": ">s1_1 comment\nMK*R\n",
+				"out.s2.faa": ">s2_1\nM*R\n",
+				"out.s3.faa": ">s3_1\nMA\n",
+			},
+		},
+		{
+			name:    "shards by record count",
+			input:   input,
+			options: "-frame=1 -shard-records=2",
+			expected: map[string]string{
+				"out.001.faa": ">s1_1 comment\nMK*R\n>s2_1\nM*R\n",
+				"out.002.faa": ">s3_1\nMA\n",
+			},
+		},
+		{
+			name:    "shards by size with pattern",
+			input:   input,
+			options: "-frame=1 -shard-size=25 -shard-pattern=part-%d.fa",
+			expected: map[string]string{
+				"part-1.fa": ">s1_1 comment\nMK*R\n",
+				"part-2.fa": ">s2_1\nM*R\n>s3_1\nMA\n",
+			},
+		},
+		{
+			name:    "large record written in several parts",
+			input:   large,
+			options: "-frame=1 -shard-records=1 -max-memory=8M -numcpu=4",
+			expected: map[string]string{
+				"out.001.faa": ">big_1\n" + strings.Repeat(strings.Repeat("M", 60)+"\n", 5000),
+				"out.002.faa": ">s1_1 comment\nMK*R\n",
+				"out.003.faa": ">s2_1\nM*R\n",
+				"out.004.faa": ">s3_1\nMA\n",
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+
+			options, err := getOptionsAndName(test.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if options.NumWorker == 0 {
+				options.NumWorker = 1
+			}
+
+			testDir := fmt.Sprintf("%s/%d", dir, i)
+			err = os.Mkdir(testDir, 0755)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if options.ShardPattern != "" {
+				options.ShardPattern = testDir + "/" + options.ShardPattern
+			}
+			files, err := transeq.TranslateToFiles(context.Background(), strings.NewReader(test.input), testDir+"/out.faa", options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != len(test.expected) {
+				t.Errorf("expected %d files but got %v", len(test.expected), files)
+			}
+			for name, want := range test.expected {
+				got, err := ioutil.ReadFile(testDir + "/" + name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want != string(got) {
+					t.Errorf("%s: expected\n%.100s\nbut got\n%.100s", name, want, got)
+				}
+			}
+		})
+	}
+
+	options, err := getOptionsAndName("-by-frame -shard-records=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = transeq.TranslateToFiles(context.Background(), strings.NewReader(input), dir+"/out.faa", options)
+	if err == nil {
+		t.Error("expected an error when using several output modes")
+	}
+}
+
+func TestPerSequenceTable(t *testing.T) {
+
+	input := ">s1 [gcode=2]\nATGTGA\n>s2 [transl_table=1] comment\nATGTGA\n>s3\nATGTGA\n>s4 [gcode=5]\nATGAGA\n"
+
+	f, err := ioutil.TempFile("", "table_map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("s2\t4\ns3\tvertebrate-mito\ns4\tThe Invertebrate Mitochondrial Code\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	tests := []struct {
+		name     string
+		options  string
+		expected string
+	}{
+		{
+			name:     "default table",
+			options:  "-frame=1",
+			expected: ">s1_1 [gcode=2]\nM*\n>s2_1 [transl_table=1] comment\nM*\n>s3_1\nM*\n>s4_1 [gcode=5]\nMR\n",
+		},
+		{
+			name:     "table by alias",
+			options:  "-frame=1 -table=Vertebrate-Mito",
+			expected: ">s1_1 [gcode=2]\nMW\n>s2_1 [transl_table=1] comment\nMW\n>s3_1\nMW\n>s4_1 [gcode=5]\nM*\n",
+		},
+		{
+			name:     "header modifiers",
+			options:  "-frame=1 -table-modifiers -table=11",
+			expected: ">s1_1 [gcode=2]\nMW\n>s2_1 [transl_table=1] comment\nM*\n>s3_1\nM*\n>s4_1 [gcode=5]\nMS\n",
+		},
+		{
+			name:     "table map",
+			options:  "-frame=1 -table-map=" + f.Name(),
+			expected: ">s1_1 [gcode=2]\nM*\n>s2_1 [transl_table=1] comment\nMW\n>s3_1\nMW\n>s4_1 [gcode=5]\nMS\n",
+		},
+		{
+			name:     "table map and header modifiers",
+			options:  "-frame=1 -table-map=" + f.Name() + " -table-modifiers",
+			expected: ">s1_1 [gcode=2]\nMW\n>s2_1 [transl_table=1] comment\nMW\n>s3_1\nMW\n>s4_1 [gcode=5]\nMS\n",
+		},
+	}
+
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			if want, got := test.expected, translate(t, input, test.options); want != got {
+				t.Errorf("expected\n%s\nbut got\n%s", want, got)
+			}
+		})
+	}
+
+	_, err = getOptionsAndName("-table=unknown-code")
+	if err == nil {
+		t.Error("expected an error for an unknown table name")
+	}
+}
+
+func TestInferTable(t *testing.T) {
+
+	gct := strings.Repeat("GCT", 20)
+	input := ">s1 comment\nATG" + gct + "TGA" + gct + "TGA" + gct + "TAA\n>s2\nATG" + gct + "TAA\n"
+
+	options, err := getOptionsAndName("-frame=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.NumWorker = 1
+
+	for _, bySequence := range []bool{true, false} {
+
+		out := bytes.NewBuffer(nil)
+		err = transeq.InferTable(strings.NewReader(input), out, options, bySequence)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(out.String(), "\n")
+
+		expected := []string{
+			"sequence\trank\ttable\tinternal_stops\treassigned_codons",
+			"s1\t1\t4\t0\tTGA:W=2",
+			"s1\t2\t10\t0\tTGA:C=2",
+		}
+		if !bySequence {
+			expected[1] = "all\t1\t4\t0\tTGA:W=2"
+			expected[2] = "all\t2\t10\t0\tTGA:C=2"
+		}
+		for i, want := range expected {
+			if got := lines[i]; want != got {
+				t.Errorf("line %d: expected\n%s\nbut got\n%s", i, want, got)
+			}
+		}
+		if want, got := "0\t2\t-", out.String(); !strings.Contains(got, want) {
+			t.Errorf("expected standard code with 2 internal stops, got\n%s", got)
+		}
+	}
+
+	// codes 12 (CTG:S) and 4 (TGA:W) have no internal stop and a single
+	// reassignment, but only CTG is used
+	input = ">s1\nATG" + strings.Repeat("CTGGCC", 20) + "\n"
+	out := bytes.NewBuffer(nil)
+	err = transeq.InferTable(strings.NewReader(input), out, options, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	used, unused := strings.Index(out.String(), "\t12\t0\tCTG:S=20\n"), strings.Index(out.String(), "\t4\t0\tTGA:W=0\n")
+	if used == -1 || unused == -1 || used > unused {
+		t.Errorf("expected code 12 to be ranked before code 4, got\n%s", out.String())
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		input    string
+		options  string
+		expected string
+	}{
+		{
+			name:     "valid",
+			input:    ">s1 comment\nATGAAATAA\n",
+			options:  "",
+			expected: "s1\t9\t0\tpass\t-\n",
+		},
+		{
+			name:     "alternative start",
+			input:    ">s1\nTTGAAATAA\n",
+			options:  "",
+			expected: "s1\t9\t0\tpass\t-\n",
+		},
+		{
+			name:     "invalid start",
+			input:    ">s1\nGTGAAATAA\n",
+			options:  "",
+			expected: "s1\t9\t0\tfail\tstart:GTG\n",
+		},
+		{
+			name:     "start of selected table",
+			input:    ">s1\nGTGAAATAA\n",
+			options:  "-table=11",
+			expected: "s1\t9\t11\tpass\t-\n",
+		},
+		{
+			name:     "all checks",
+			input:    ">s1\nCTATAGAANTA\n",
+			options:  "-table=2",
+			expected: "s1\t11\t2\tfail\tlength,start:CTA,stop:AAN,internal_stop:1,ambiguous:1\n",
+		},
+		{
+			name:     "missing stop",
+			input:    ">s1\nATGAAATGA\n",
+			options:  "-table=2",
+			expected: "s1\t9\t2\tfail\tstop:TGA\n",
+		},
+		{
+			name:     "selenocysteine",
+			input:    ">s1\nATGTGATAA\n",
+			options:  "-selenocysteine",
+			expected: "s1\t9\t0\tpass\t-\n",
+		},
+		{
+			name:     "recoded terminal stop",
+			input:    ">s1\nATGTGAAAATGA\n",
+			options:  "-selenocysteine",
+			expected: "s1\t12\t0\tpass\t-\n",
+		},
+		{
+			name:     "recoded terminal stop pyrrolysine",
+			input:    ">s1\nATGTAGAAATAG\n",
+			options:  "-pyrrolysine",
+			expected: "s1\t12\t0\tpass\t-\n",
+		},
+	}
+
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+
+			options, err := getOptionsAndName(test.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			options.NumWorker = 1
+
+			out := bytes.NewBuffer(nil)
+			invalid, err := transeq.Validate(strings.NewReader(test.input), out, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want, got := "sequence\tlength\ttable\tstatus\tviolations\n"+test.expected, out.String(); want != got {
+				t.Errorf("expected\n%s\nbut got\n%s", want, got)
+			}
+			if want, got := strings.Contains(test.expected, "fail"), invalid > 0; want != got {
+				t.Errorf("expected invalid sequence: %v, but got %d invalid sequences", want, invalid)
+			}
+		})
+	}
+}
+
+func TestTables(t *testing.T) {
+
+	out := bytes.NewBuffer(nil)
+	err := transeq.WriteTableDiff(out, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `--- 1. Standard Code
++++ 2. The Vertebrate Mitochondrial Code
+TTG  L Leu   i  L Leu
+TGA  * Ter      W Trp
+CTG  L Leu   i  L Leu
+ATT  I Ile      I Ile   i
+ATC  I Ile      I Ile   i
+ATA  I Ile      M Met   i
+AGA  R Arg      * Ter
+AGG  R Arg      * Ter
+GTG  V Val      V Val   i
+`
+	if want, got := expected, out.String(); want != got {
+		t.Errorf("expected\n%s\nbut got\n%s", want, got)
+	}
+
+	out.Reset()
+	err = transeq.WriteTableGrid(out, 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "  TTG L Leu   i  TCG S Ser      TAG * Ter      TGG W Trp\n", out.String(); !strings.Contains(got, want) {
+		t.Errorf("expected grid to contain\n%s\nbut got\n%s", want, got)
+	}
+
+	err = transeq.WriteTableGrid(out, 7)
+	if err == nil {
+		t.Error("expected an error for an invalid table code")
+	}
+}
+
+func TestTableContents(t *testing.T) {
+
+	options, err := getOptionsAndName("-table=standard -selenocysteine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := transeq.TableContents(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codons, ok := contents[0]
+	if len(contents) != 1 || !ok {
+		t.Fatalf("expected the contents of the standard code only, got %v", contents)
+	}
+	for codon, want := range map[string]string{"ATG": "M", "TGA": "U", "AGA": "R", "TAG": "*"} {
+		if got := codons[codon]; want != got {
+			t.Errorf("%s: expected %s but got %s", codon, want, got)
+		}
+	}
+	if len(codons) != 64 {
+		t.Errorf("expected 64 codons but got %d", len(codons))
+	}
+}
+
+func TestCodonRange(t *testing.T) {
+
+	tests := []struct {
+		frame       int
+		aaPos       int
+		seqLen      int
+		alternative bool
+		from, to    int
+	}{
+		{frame: 1, aaPos: 1, seqLen: 10, from: 1, to: 3},
+		{frame: 2, aaPos: 3, seqLen: 10, from: 8, to: 10},
+		{frame: 1, aaPos: 4, seqLen: 10, from: 10, to: 10},
+		{frame: -1, aaPos: 1, seqLen: 10, from: 9, to: 7},
+		{frame: -1, aaPos: 1, seqLen: 10, alternative: true, from: 10, to: 8},
+		{frame: -3, aaPos: 3, seqLen: 9, from: 2, to: 1},
+	}
+
+	for _, test := range tests {
+		from, to, err := transeq.CodonRange(test.frame, test.aaPos, test.seqLen, test.alternative)
+		if err != nil {
+			t.Error(err)
+		}
+		if from != test.from || to != test.to {
+			t.Errorf("frame %d, aa %d: expected %d-%d but got %d-%d", test.frame, test.aaPos, test.from, test.to, from, to)
+		}
+	}
+
+	// check that both functions are consistent
+	for _, alternative := range []bool{false, true} {
+		for seqLen := 9; seqLen <= 11; seqLen++ {
+			for _, frame := range []int{1, 2, 3, -1, -2, -3} {
+				for aaPos := 1; ; aaPos++ {
+					from, to, err := transeq.CodonRange(frame, aaPos, seqLen, alternative)
+					if err != nil {
+						break
+					}
+					for _, ntPos := range []int{from, to} {
+						got, err := transeq.AAPosition(frame, ntPos, seqLen, alternative)
+						if err != nil {
+							t.Error(err)
+						}
+						if got != aaPos {
+							t.Errorf("frame %d, nt %d: expected aa %d but got %d", frame, ntPos, aaPos, got)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	if _, err := transeq.AAPosition(2, 1, 10, false); err == nil {
+		t.Error("expected an error for nucleotide before the first codon")
+	}
+	if _, _, err := transeq.CodonRange(4, 1, 10, false); err == nil {
+		t.Error("expected an error for invalid frame")
+	}
+}
+
+// benchmarkInput returns a fasta file of nbSeq random sequences of
+// seqLen nucleotides
+func benchmarkInput(nbSeq, seqLen int) []byte {
+
+	r := rand.New(rand.NewSource(1))
+	buf := bytes.NewBuffer(make([]byte, 0, nbSeq*(seqLen+seqLen/60+20)))
+	for i := 0; i < nbSeq; i++ {
+		fmt.Fprintf(buf, ">seq%d\n", i)
+		for j := 0; j < seqLen; j++ {
+			buf.WriteByte("ACGT"[r.Intn(4)])
+			if (j+1)%60 == 0 {
+				buf.WriteByte('\n')
+			}
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+func benchmarkTranslate(b *testing.B, numWorker int) {
+
+	input := benchmarkInput(200, 50000)
+	options, err := getOptionsAndName("-frame=6")
+	if err != nil {
+		b.Fatal(err)
+	}
+	options.NumWorker = numWorker
+
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err = transeq.Translate(bytes.NewReader(input), ioutil.Discard, options)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTranslate6Frames(b *testing.B) {
+	benchmarkTranslate(b, 1)
+}
+
+func BenchmarkTranslate6FramesParallel(b *testing.B) {
+	benchmarkTranslate(b, runtime.NumCPU())
+}
+
+func TestTranslateFiles(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gotranseq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	inputs := map[string]string{
+		"a/x.fa":  ">s1 comment\nATGAAATAG\n>s2\nATGCCC\n",
+		"a/y.fna": ">s3\nATGGCA\n",
+		"b/x.fa":  ">s4\nATGTGG\n",
+	}
+	for name, content := range inputs {
+		err = os.MkdirAll(dir+"/"+name[:1], 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(dir+"/"+name, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	x, y, otherX := dir+"/a/x.fa", dir+"/a/y.fna", dir+"/b/x.fa"
+
+	tests := []struct {
+		name      string
+		inputs    []string
+		template  string
+		options   string
+		numWorker int
+		expected  map[string]string
+	}{
+		{
+			name:      "merged output",
+			inputs:    []string{x, y},
+			options:   "-frame=1",
+			numWorker: 1,
+			expected: map[string]string{
+				"merged.faa": ">s1_1 source=" + x + " comment\nMK*\n>s2_1 source=" + x + "\nMP\n>s3_1 source=" + y + "\nMA\n",
+			},
+		},
+		{
+			name:      "output per input",
+			inputs:    []string{x, y},
+			template:  "{dir}/{name}.faa",
+			options:   "-frame=1",
+			numWorker: 4,
+			expected: map[string]string{
+				"a/x.faa": ">s1_1 comment\nMK*\n>s2_1\nMP\n",
+				"a/y.faa": ">s3_1\nMA\n",
+			},
+		},
+		{
+			name:      "output per input by frame",
+			inputs:    []string{x, otherX},
+			template:  "{dir}/{name}.faa",
+			options:   "-frame=1,-1 -frame-names=signed -by-frame",
+			numWorker: 2,
+			expected: map[string]string{
+				"a/x.frame+1.faa": ">s1_+1 comment\nMK*\n>s2_+1\nMP\n",
+				"a/x.frame-1.faa": ">s1_-1 comment\nLFH\n>s2_-1\nGH\n",
+				"b/x.frame+1.faa": ">s4_+1\nMW\n",
+				"b/x.frame-1.faa": ">s4_-1\nPH\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+
+			options, err := getOptionsAndName(test.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			options.NumWorker = test.numWorker
+
+			files, err := transeq.TranslateFiles(context.Background(), test.inputs, dir+"/merged.faa", test.template, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != len(test.expected) {
+				t.Errorf("expected %d files but got %v", len(test.expected), files)
+			}
+			for name, want := range test.expected {
+				got, err := ioutil.ReadFile(dir + "/" + name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want != string(got) {
+					t.Errorf("%s: expected\n%s\nbut got\n%s", name, want, got)
+				}
+			}
+		})
+	}
+
+	options, err := getOptionsAndName("-frame=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = transeq.TranslateFiles(context.Background(), []string{x, otherX}, "", dir+"/{name}.faa", options)
+	if err == nil {
+		t.Error("expected an error when two inputs have the same output file")
+	}
+	_, err = transeq.TranslateFiles(context.Background(), []string{x, y}, "", dir+"/out.faa", options)
+	if err == nil {
+		t.Error("expected an error when the template has no {name}")
+	}
+}
+
+func TestAtomicOutput(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gotranseq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := ">s1\nATGAAATAG\n"
+	out := dir + "/out.faa"
+	err = ioutil.WriteFile(out, []byte("previous"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options, err := getOptionsAndName("-frame=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.NumWorker = 2
+	_, err = transeq.TranslateToFiles(context.Background(), strings.NewReader(input), out, options)
+	if err == nil {
+		t.Error("expected an error when the output file already exists")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	options.Force = true
+	_, err = transeq.TranslateToFiles(ctx, strings.NewReader(input), out, options)
+	if err != context.Canceled {
+		t.Errorf("expected %v but got %v", context.Canceled, err)
+	}
+	assertFiles(t, dir, map[string]string{"out.faa": "previous"})
+
+	_, err = transeq.TranslateToFiles(context.Background(), strings.NewReader(input), out, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFiles(t, dir, map[string]string{"out.faa": ">s1_1\nMK*\n"})
+
+	// the output has the permissions of a file created by os.Create
+	created, err := os.Create(dir + "/created")
+	if err != nil {
+		t.Fatal(err)
+	}
+	created.Close()
+	outInfo, err := os.Stat(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	createdInfo, err := os.Stat(created.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outInfo.Mode() != createdInfo.Mode() {
+		t.Errorf("expected the output to have mode %v but got %v", createdInfo.Mode(), outInfo.Mode())
+	}
+}
+
+// assertFiles checks that dir only holds the expected files
+func assertFiles(t *testing.T, dir string, expected map[string]string) {
+
+	t.Helper()
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != len(expected) {
+		t.Errorf("expected %d files but got %d", len(expected), len(files))
+	}
+	for _, f := range files {
+		got, err := ioutil.ReadFile(dir + "/" + f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, ok := expected[f.Name()]; !ok || want != string(got) {
+			t.Errorf("%s: expected\n%s\nbut got\n%s", f.Name(), want, got)
+		}
+	}
+}
+
+// endlessInput generates fasta records endlessly, and calls onRead
+// after each read
+type endlessInput struct {
+	reads  int
+	onRead func(reads int)
+}
+
+func (e *endlessInput) Read(p []byte) (int, error) {
+	record := ">s1 comment\n" + strings.Repeat("ATGAAATAGCGC", 100) + "\n"
+	n := 0
+	for n+len(record) <= len(p) {
+		n += copy(p[n:], record)
+	}
+	e.reads++
+	if e.onRead != nil {
+		e.onRead(e.reads)
+	}
+	return n, nil
+}
+
+func TestTranslateContext(t *testing.T) {
+
+	options, err := getOptionsAndName("-frame=6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.NumWorker = 4
+
+	tests := []struct {
+		name     string
+		deadline time.Duration
+		// nb of reads before the context is cancelled
+		cancelAfter int
+		expected    error
+	}{
+		{
+			name:        "cancelled before",
+			cancelAfter: 0,
+			expected:    context.Canceled,
+		},
+		{
+			name:        "cancelled while reading",
+			cancelAfter: 3,
+			expected:    context.Canceled,
+		},
+		{
+			name:     "deadline exceeded",
+			deadline: 100 * time.Millisecond,
+			expected: context.DeadlineExceeded,
+		},
+	}
+
+	// InferTableContext and ValidateContext stop the same way
+	runs := []struct {
+		name string
+		run  func(ctx context.Context, in io.Reader) error
+	}{
+		{
+			name: "translate",
+			run: func(ctx context.Context, in io.Reader) error {
+				return transeq.TranslateContext(ctx, in, ioutil.Discard, options)
+			},
+		},
+		{
+			name: "infer",
+			run: func(ctx context.Context, in io.Reader) error {
+				return transeq.InferTableContext(ctx, in, ioutil.Discard, options, true)
+			},
+		},
+		{
+			name: "validate",
+			run: func(ctx context.Context, in io.Reader) error {
+				_, err := transeq.ValidateContext(ctx, in, ioutil.Discard, options)
+				return err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, r := range runs {
+			test, run := tt, r
+			t.Run(run.name+" "+test.name, func(t *testing.T) {
+
+				goroutines := runtime.NumGoroutine()
+
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				if test.deadline != 0 {
+					ctx, cancel = context.WithTimeout(ctx, test.deadline)
+					defer cancel()
+				}
+				input := &endlessInput{}
+				if test.deadline == 0 {
+					if test.cancelAfter == 0 {
+						cancel()
+					}
+					input.onRead = func(reads int) {
+						if reads == test.cancelAfter {
+							cancel()
+						}
+					}
+				}
+
+				err := run.run(ctx, input)
+				if err != test.expected {
+					t.Errorf("expected %v but got %v", test.expected, err)
+				}
+				if n := runtime.NumGoroutine(); n != goroutines {
+					t.Errorf("expected %d goroutines after the run but got %d", goroutines, n)
+				}
+			})
+		}
+	}
+}
+
+// failingInput returns an error once limit bytes have been read
+type failingInput struct {
+	r     io.Reader
+	limit int
+}
+
+func (f *failingInput) Read(p []byte) (int, error) {
+	if f.limit <= 0 {
+		return 0, fmt.Errorf("input unavailable")
+	}
+	if len(p) > f.limit {
+		p = p[:f.limit]
+	}
+	n, err := f.r.Read(p)
+	f.limit -= n
+	return n, err
+}
+
+func TestResume(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gotranseq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var input strings.Builder
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&input, ">s%d\n%s\n", i, strings.Repeat("ATGAAATAGCGC", 50+i%100))
+	}
+
+	options, err := getOptionsAndName("-frame=6 -resume -max-memory=4M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.NumWorker = 1
+
+	var expected bytes.Buffer
+	err = transeq.Translate(strings.NewReader(input.String()), &expected, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := dir + "/out.faa"
+	options.NumWorker = 4
+	_, err = transeq.TranslateToFiles(context.Background(), &failingInput{r: strings.NewReader(input.String()), limit: input.Len() / 2}, out, options)
+	if err == nil {
+		t.Fatal("expected an error when the input fails")
+	}
+	data, err := ioutil.ReadFile(out + ".checkpoint")
+	if err != nil {
+		t.Fatalf("expected a checkpoint after a failed run: %v", err)
+	}
+	var progress struct {
+		InputOffset int64 `json:"input_offset"`
+		OutputSize  int64 `json:"output_size"`
+	}
+	err = json.Unmarshal(data, &progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.InputOffset == 0 || progress.InputOffset > int64(input.Len()/2) {
+		t.Errorf("expected a checkpoint in the first half of the input but got offset %d", progress.InputOffset)
+	}
+
+	changed := options
+	changed.Frame = "1"
+	_, err = transeq.TranslateToFiles(context.Background(), strings.NewReader(input.String()), out, changed)
+	if err == nil {
+		t.Error("expected an error when resuming with different options")
+	}
+
+	partial, err := ioutil.ReadFile(out + ".partial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Truncate(out+".partial", progress.OutputSize-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = transeq.TranslateToFiles(context.Background(), strings.NewReader(input.String()), out, options)
+	if err == nil {
+		t.Error("expected an error when resuming with an output shorter than the checkpoint")
+	}
+	err = ioutil.WriteFile(out+".partial", partial, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = transeq.TranslateToFiles(context.Background(), strings.NewReader(input.String()), out, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFiles(t, dir, map[string]string{"out.faa": expected.String()})
+}
+
+func TestDefaultOptions(t *testing.T) {
+
+	out := bytes.NewBuffer(nil)
+	err := transeq.Translate(strings.NewReader(">s1\nATGNNNTAGATG\n"), out, transeq.Options{Frame: "1", NumWorker: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ">s1_1\nMX*M\n"
+	if out.String() != expected {
+		t.Errorf("expected\n%s\nbut got\n%s", expected, out.String())
+	}
+}
+
+func TestORF(t *testing.T) {
+
+	input := ">s1 comment\nCCCATGAAATAGCCCATGCCCGGGTAACCCCCC\n"
+
+	options, err := getOptionsAndName("-frame=1,-1 -split -min-fragment=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.ORF = true
+	options.NumWorker = 1
+
+	out := bytes.NewBuffer(nil)
+	err = transeq.Translate(strings.NewReader(input), out, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ">s1_1_2-3 nt=4-9 comment\nMK\n>s1_1_6-8 nt=16-24 comment\nMPG\n"
+	if out.String() != expected {
+		t.Errorf("expected\n%s\nbut got\n%s", expected, out.String())
+	}
+}
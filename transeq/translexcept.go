@@ -0,0 +1,147 @@
+package transeq
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// one letter code of the amino acids, as used in the INSDC
+// /transl_except qualifier
+var aaAbbreviations = map[string]byte{
+	"Ala":   'A',
+	"Arg":   'R',
+	"Asn":   'N',
+	"Asp":   'D',
+	"Asx":   'B',
+	"Cys":   'C',
+	"Gln":   'Q',
+	"Glu":   'E',
+	"Glx":   'Z',
+	"Gly":   'G',
+	"His":   'H',
+	"Ile":   'I',
+	"Leu":   'L',
+	"Lys":   'K',
+	"Met":   'M',
+	"Phe":   'F',
+	"Pro":   'P',
+	"Pyl":   pyrrolysine,
+	"Sec":   selenocysteine,
+	"Ser":   'S',
+	"Thr":   'T',
+	"Trp":   'W',
+	"Tyr":   'Y',
+	"Val":   'V',
+	"Xle":   'J',
+	"TERM":  stop,
+	"OTHER": unknown,
+}
+
+var translExceptRegexp = regexp.MustCompile(`^\(pos:(complement\()?(\d+)(?:\.\.(\d+))?\)?,aa:(\w+)\)$`)
+
+// translExcept forces the translation of a codon
+type translExcept struct {
+	// 1-based coordinates of the codon on the forward strand,
+	// from <= to
+	from, to int
+	// whether the codon is on the reverse strand
+	complement bool
+	aa         byte
+}
+
+// loadTranslExcept reads a tab separated file where each line
+// looks like
+//
+//	<sequenceID>	(pos:213..215,aa:Sec)
+//
+// The end of the range may be omitted for a single nucleotide, like in
+// '(pos:7,aa:TERM)' for a stop codon completed by the polyA tail.
+// and returns the exceptions by sequence id
+func loadTranslExcept(filename string) (map[string][]translExcept, error) {
+
+	if filename == "" {
+		return nil, nil
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	excepts := map[string][]translExcept{}
+
+	scanner := bufio.NewScanner(f)
+	lineNb := 0
+	for scanner.Scan() {
+
+		lineNb++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid line %d in %s: expected 2 tab separated fields but got %d", lineNb, filename, len(fields))
+		}
+		m := translExceptRegexp.FindStringSubmatch(strings.Replace(fields[1], " ", "", -1))
+		if m == nil {
+			return nil, fmt.Errorf("invalid line %d in %s: %s", lineNb, filename, fields[1])
+		}
+		e := translExcept{complement: m[1] != ""}
+		e.from, _ = strconv.Atoi(m[2])
+		e.to = e.from
+		position := m[2]
+		if m[3] != "" {
+			e.to, _ = strconv.Atoi(m[3])
+			position += ".." + m[3]
+		}
+		if e.from < 1 || e.to < e.from || e.to-e.from > 2 {
+			return nil, fmt.Errorf("invalid line %d in %s: invalid codon position %s", lineNb, filename, position)
+		}
+		aa, ok := aaAbbreviations[m[4]]
+		if !ok {
+			return nil, fmt.Errorf("invalid line %d in %s: unknown amino acid %s", lineNb, filename, m[4])
+		}
+		e.aa = aa
+
+		id := strings.TrimPrefix(fields[0], ">")
+		excepts[id] = append(excepts[id], e)
+	}
+	return excepts, scanner.Err()
+}
+
+// applyExcepts replaces the amino acids of prot, the translation of frame
+// frameIndex of the sequence, coded by the codons listed in w.excepts
+func (w *writer) applyExcepts(seqHeader []byte, frameIndex int, prot []byte) {
+
+	excepts, ok := w.excepts[string(sequenceID(seqHeader))]
+	if !ok {
+		return
+	}
+
+	offset := w.offsets[frameIndex]
+	for _, e := range excepts {
+
+		if e.complement != (frameIndex >= 3) {
+			continue
+		}
+		// 0-based position of the first nucleotide of the codon
+		// on the strand of the frame
+		first := e.from - 1
+		if e.complement {
+			first = w.seqLen - e.to
+		}
+		if first < offset || (first-offset)%3 != 0 {
+			// the codon is not in this frame
+			continue
+		}
+		if i := (first - offset) / 3; i < len(prot) {
+			prot[i] = e.aa
+		}
+	}
+}
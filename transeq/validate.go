@@ -0,0 +1,211 @@
+package transeq
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/feliixx/gotranseq/ncbicode"
+)
+
+// names of the checks run by Validate, as written in the report
+const (
+	checkLength       = "length"
+	checkStart        = "start"
+	checkStop         = "stop"
+	checkInternalStop = "internal_stop"
+	checkAmbiguous    = "ambiguous"
+)
+
+// nucleotide of each code, used to write codons in the report
+const nucleotides = "NACTG"
+
+// validator checks that sequences are valid CDS
+type validator struct {
+	tables *codeTables
+	// code arrays without the recoding of Options.Selenocysteine and
+	// Options.Pyrrolysine, as a recoded stop codon still ends a CDS
+	terminal *codeTables
+	// start codons by NCBI code, as index in the code arrays. It's
+	// shared read-only between workers
+	starts map[int]map[uint32]bool
+	buf    []byte
+	// nb of invalid sequences processed
+	invalid int
+}
+
+// loadStartCodons returns the start codons of each NCBI code in tables
+func loadStartCodons(tables *codeTables) (map[int]map[uint32]bool, error) {
+
+	starts := map[int]map[uint32]bool{}
+	for code := range tables.arrays {
+		t, err := ncbicode.GetTable(code)
+		if err != nil {
+			return nil, err
+		}
+		starts[code] = map[uint32]bool{}
+		for _, codon := range t.StartCodons {
+			starts[code][codonIndex(letterCode[codon[0]], letterCode[codon[1]], letterCode[codon[2]])] = true
+		}
+	}
+	return starts, nil
+}
+
+// validate runs all the checks on the sequence, and appends a line
+// describing the violations found to v.buf
+func (v *validator) validate(sequence encodedSequence) {
+
+	code := v.tables.codeFor(sequence.header())
+	codes := v.tables.arrays[code]
+	terminalCodes := v.terminal.arrays[code]
+
+	seqLen := sequence.nuclSeqSize()
+	first := sequence.headerSize()
+	// position of the last complete codon
+	last := first + seqLen - seqLen%3 - 3
+
+	var violations []byte
+	addViolation := func(check string, detail []byte) {
+		if len(violations) > 0 {
+			violations = append(violations, ',')
+		}
+		violations = append(violations, check...)
+		if detail != nil {
+			violations = append(violations, ':')
+			violations = append(violations, detail...)
+		}
+	}
+
+	if seqLen == 0 || seqLen%3 != 0 {
+		addViolation(checkLength, nil)
+	}
+	if seqLen >= 3 {
+		if !v.starts[code][sequence.codonIndexAt(first)] {
+			addViolation(checkStart, codonName(sequence, first))
+		}
+		if terminalCodes[sequence.codonIndexAt(last)] != stop {
+			addViolation(checkStop, codonName(sequence, last))
+		}
+	}
+
+	internalStops := 0
+	for pos := first; pos < last; pos += 3 {
+		if codes[sequence.codonIndexAt(pos)] == stop {
+			internalStops++
+		}
+	}
+	if internalStops > 0 {
+		addViolation(checkInternalStop, strconv.AppendInt(nil, int64(internalStops), 10))
+	}
+
+	ambiguous := 0
+	for _, n := range sequence[first:] {
+		if n == nCode {
+			ambiguous++
+		}
+	}
+	if ambiguous > 0 {
+		addViolation(checkAmbiguous, strconv.AppendInt(nil, int64(ambiguous), 10))
+	}
+
+	v.buf = append(v.buf, sequenceID(sequence.header())...)
+	v.buf = append(v.buf, '\t')
+	v.buf = strconv.AppendInt(v.buf, int64(seqLen), 10)
+	v.buf = append(v.buf, '\t')
+	v.buf = strconv.AppendInt(v.buf, int64(code), 10)
+	if len(violations) == 0 {
+		v.buf = append(v.buf, "\tpass\t-\n"...)
+		return
+	}
+	v.invalid++
+	v.buf = append(v.buf, "\tfail\t"...)
+	v.buf = append(v.buf, violations...)
+	v.buf = append(v.buf, '\n')
+}
+
+func codonName(sequence encodedSequence, pos int) []byte {
+	return []byte{nucleotides[sequence[pos]], nucleotides[sequence[pos+1]], nucleotides[sequence[pos+2]]}
+}
+
+// header of the report written by Validate
+const validateHeader = "sequence\tlength\ttable\tstatus\tviolations\n"
+
+// Validate checks that each sequence is a complete CDS, and writes a tab
+// separated report with one line per sequence to out. It returns the
+// number of sequences that failed at least one check.
+//
+// The checks are:
+//
+//	length          the length of the sequence is a multiple of 3
+//	start           the first codon is a start codon of the NCBI code
+//	stop            the last codon is a stop codon, even if it's recoded
+//	                by Options.Selenocysteine or Options.Pyrrolysine
+//	internal_stop   there is no other stop codon in the sequence
+//	ambiguous       the sequence contains only A, C, G, T or U
+//
+// The NCBI code of each sequence is selected from options the same way
+// as in Translate. Failed checks are written as a comma separated list,
+// with the offending codon or the number of occurrences when relevant,
+// for example 'start:CTA,internal_stop:2'
+func Validate(inputSequence io.Reader, out io.Writer, options Options) (int, error) {
+	return ValidateContext(context.Background(), inputSequence, out, options)
+}
+
+// ValidateContext is like Validate, but stops once ctx is cancelled, and
+// returns ctx.Err() in this case, as TranslateContext does
+func ValidateContext(ctx context.Context, inputSequence io.Reader, out io.Writer, options Options) (int, error) {
+
+	// stop codons have to be kept to be detected
+	options.Clean = false
+	tables, err := newCodeTables(options)
+	if err != nil {
+		return 0, err
+	}
+	unrecoded := options
+	unrecoded.Selenocysteine, unrecoded.Pyrrolysine = false, false
+	terminal, err := newCodeTables(unrecoded)
+	if err != nil {
+		return 0, err
+	}
+	starts, err := loadStartCodons(tables)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = out.Write([]byte(validateHeader))
+	if err != nil {
+		return 0, fmt.Errorf("fail to write to output file: %v", err)
+	}
+
+	var mu sync.Mutex
+	invalid := 0
+
+	err = process(ctx, inputSequence, out, options, func(o *output) worker {
+
+		v := &validator{
+			tables:   tables,
+			terminal: terminal,
+			starts:   starts,
+			buf:      make([]byte, 0, o.bufferSize),
+		}
+
+		return worker{
+			sequence: func(sequence encodedSequence) {
+				v.validate(sequence)
+				if len(v.buf) > o.bufferSize {
+					v.buf = o.write(v.buf, nil)
+				}
+			},
+			done: func() {
+				v.buf = o.write(v.buf, nil)
+
+				mu.Lock()
+				invalid += v.invalid
+				mu.Unlock()
+			},
+		}
+	})
+	return invalid, err
+}
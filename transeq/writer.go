@@ -5,177 +5,567 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"strconv"
+	"sync"
 )
 
 const (
 	mb = 1 << (10 * 2)
-	// size of the buffer for writing to file
+	// default size of the buffer for writing to file
 	maxBufferSize = 1 * mb
-	// suffixes to add to sequence id for each frame
-	suffixes = "123456"
 	// max line size for the output file
 	maxLineSize = 60
 	// specific codons
-	stop    = '*'
-	unknown = 'X'
+	stop           = '*'
+	unknown        = 'X'
+	selenocysteine = 'U'
+	pyrrolysine    = 'O'
 )
 
 type writer struct {
-	codes            [arrayCodeSize]byte
+	tables           *codeTables
+	out              *output
 	buf              []byte
-	currentLineLen   int
-	startPos         [3]int
-	frameIndex       int
 	framesToGenerate [6]int
 	reverse          bool
 	alternative      bool
 	trim             bool
-	// if in trim mode, nb of bytes to trim (nb of successive 'X', '*' and '\n'
-	// from right end of the sequence)
-	toTrim int
+	filter           filter
+	best             string
+	split            bool
+	orf              bool
+	minFragment      int
+	coords           bool
+	completeStop     bool
+	leadingPartial   string
+	trailingPartial  string
+	trimStop         bool
+	trimLeadingX     bool
+	reportStop       bool
+	circular         bool
+	// character used for internal stop codons, 0 to keep '*'
+	stopChar byte
+	// codon <-> AA array used for the current sequence
+	codes *[arrayCodeSize]byte
+	// names of the frames, used as suffix for sequence id
+	frameNames [6]string
+	// amino acids to force at specific positions, by sequence id
+	excepts map[string][]translExcept
+	// translation of each frame of the current sequence. Frames are
+	// translated first and written once all of them are available, so
+	// they can be filtered and compared
+	prots [6][]byte
+	// position of the first codon of each frame, relative to the
+	// start of its strand
+	offsets [6]int
+	// length of the nucleic sequence being translated
+	seqLen int
+	// whether the sequence being translated is circular
+	seqCircular bool
+	// whether each frame ends with a stop codon, before trimming
+	terminalStop [6]bool
+	// stats of the frame selected when w.best is set
+	bestStats frameStats
+	// whether the record being written has been partially written to
+	// w.out, which is then locked until the end of the record
+	partial bool
+	// counters of the records translated since the last chunk
+	counts counters
+	// end of the records in w.buf, used to route them to several files
+	spans []recordSpan
+	// id of the sequence being translated, only set when routing by
+	// record
+	seqID string
+	// name of the input of the sequence, added to the headers if not
+	// empty
+	source string
 }
 
-func newWriter(codes [arrayCodeSize]byte, framesToGenerate [6]int, reverse, alternative, trim bool) *writer {
-	return &writer{
-		codes:            codes,
-		buf:              make([]byte, 0, maxBufferSize),
-		startPos:         [3]int{0, 1, 2},
+func newWriter(tables *codeTables, framesToGenerate [6]int, reverse bool, excepts map[string][]translExcept, options Options, out *output) *writer {
+	w := &writer{
+		tables:           tables,
+		codes:            tables.arrays[tables.defaultTable],
+		out:              out,
 		framesToGenerate: framesToGenerate,
+		frameNames:       frameNames[options.FrameNames],
 		reverse:          reverse,
-		alternative:      alternative,
-		trim:             trim,
+		alternative:      options.Alternative,
+		trim:             options.Trim,
+		filter:           newFilter(options),
+		best:             options.Best,
+		split:            options.Split,
+		orf:              options.ORF,
+		minFragment:      options.MinFragment,
+		coords:           options.Coords,
+		completeStop:     options.CompleteStop,
+		leadingPartial:   options.LeadingPartial,
+		trailingPartial:  options.TrailingPartial,
+		trimStop:         options.TrimStop,
+		trimLeadingX:     options.TrimLeadingX,
+		reportStop:       options.ReportStop,
+		circular:         options.Circular,
+		excepts:          excepts,
 	}
-}
-
-func (w *writer) reset() {
-	w.frameIndex = 0
-	if w.reverse && !w.alternative {
-		w.startPos[0], w.startPos[1], w.startPos[2] = 0, 1, 2
+	if options.StopChar != "" {
+		w.stopChar = options.StopChar[0]
 	}
+	return w
 }
 
 func (w *writer) translate(sequence encodedSequence) {
 
-	w.reset()
-	w.translate3Frames(sequence)
+	w.seqLen = sequence.nuclSeqSize()
+	w.counts.records++
+	w.counts.bases += int64(w.seqLen)
+	if w.out.router != nil && w.out.router.byRecord {
+		w.seqID = string(sequenceID(sequence.header()))
+	}
+	if w.tables.perSequence() {
+		w.codes = w.tables.forSequence(sequence.header())
+	}
+	w.seqCircular = w.circular
+	if topology, ok := headerModifier(sequence.header(), "topology"); ok {
+		w.seqCircular = string(topology) == "circular"
+	}
+	w.translate3Frames(sequence, 0)
 
 	if w.reverse {
+		sequence.reverseComplement()
+		w.translate3Frames(sequence, 3)
+	}
+	w.writeFrames(sequence.header())
 
-		if !w.alternative {
-			// Staden convention: Frame -1 is the reverse-complement of the sequence
-			// having the same codon phase as frame 1. Frame -2 is the same phase as
-			// frame 2. Frame -3 is the same phase as frame 3
-			//
-			// use the matrix to keep track of the forward frame as it depends on the
-			// length of the sequence
-			switch sequence.nuclSeqSize() % 3 {
-			case 0:
-				w.startPos[0], w.startPos[1], w.startPos[2] = 0, 2, 1
-			case 1:
-				w.startPos[0], w.startPos[1], w.startPos[2] = 1, 0, 2
-			case 2:
-				w.startPos[0], w.startPos[1], w.startPos[2] = 2, 1, 0
-			}
+	for i, prot := range w.prots {
+		if cap(prot) > w.out.retainSize {
+			w.prots[i] = nil
 		}
-		sequence.reverseComplement()
-		w.translate3Frames(sequence)
 	}
 }
 
-func (w *writer) translate3Frames(sequence encodedSequence) {
+// translate3Frames translates the sequence in the three frames starting
+// at firstFrame, and store the result in w.prots[firstFrame:firstFrame+3]
+func (w *writer) translate3Frames(sequence encodedSequence, firstFrame int) {
 
-	for _, startPos := range w.startPos {
+	for frameIndex := firstFrame; frameIndex < firstFrame+3; frameIndex++ {
 
-		if w.framesToGenerate[w.frameIndex] == 0 {
-			w.frameIndex++
+		if w.framesToGenerate[frameIndex] == 0 {
 			continue
 		}
-		w.writeHeader(sequence.header())
+		prot := w.prots[frameIndex][:0]
+		if size := w.seqLen/3 + 2; cap(prot) < size {
+			// allocated once, as growing the translation of a large
+			// record by appending would hold up to twice its size
+			prot = make([]byte, 0, size)
+		}
+		startPos := frameOffset(frameIndex, w.seqLen, w.alternative)
+		w.offsets[frameIndex] = startPos
 
-		// read the sequence 3 letters at a time, starting at a specific position
-		// corresponding to the frame
-		for pos := sequence.headerSize() + startPos; pos < len(sequence)-2; pos += 3 {
-			index := uint32(sequence[pos]) | uint32(sequence[pos+1])<<8 | uint32(sequence[pos+2])<<16
-			w.writeAA(w.codes[index])
+		if w.seqCircular {
+			prot = w.translateCircular(sequence, startPos, prot)
+		} else {
+			prot = w.translateLinear(sequence, frameIndex, startPos, prot)
 		}
 
-		switch (sequence.nuclSeqSize() - startPos) % 3 {
-		case 2:
+		if len(w.excepts) > 0 {
+			w.applyExcepts(sequence.header(), frameIndex, prot)
+		}
+
+		w.terminalStop[frameIndex] = len(prot) > 0 && prot[len(prot)-1] == stop
+
+		if w.trim {
+			// remove all 'X' and '*' from the right end of the translation
+			end := len(prot)
+			for end > 0 && (prot[end-1] == stop || prot[end-1] == unknown) {
+				end--
+			}
+			prot = prot[:end]
+		}
+		if w.trimStop && len(prot) > 0 && prot[len(prot)-1] == stop {
+			prot = prot[:len(prot)-1]
+		}
+		if w.trimLeadingX {
+			start := 0
+			for start < len(prot) && prot[start] == unknown {
+				start++
+			}
+			// keep coordinates consistent with the trimmed translation
+			w.offsets[frameIndex] += 3 * start
+			prot = prot[:copy(prot, prot[start:])]
+		}
+		w.prots[frameIndex] = prot
+	}
+}
+
+// translateLinear appends the translation of a frame starting at startPos
+// to prot, handling the incomplete codons at both ends according to the
+// partial codon policies
+func (w *writer) translateLinear(sequence encodedSequence, frameIndex, startPos int, prot []byte) []byte {
+
+	if startPos > 0 && startPos <= sequence.nuclSeqSize() && w.leadingPartial != partialDrop {
+		// the first codon is incomplete. Its virtual start is before
+		// the start of the strand, so coordinates are computed as if
+		// it was complete
+		w.offsets[frameIndex] = startPos - 3
+		prot = append(prot, unknown)
+	}
+
+	// read the sequence 3 letters at a time, starting at a specific position
+	// corresponding to the frame
+	if first := sequence.headerSize() + startPos; first < len(sequence) {
+		prot = translateCodons(w.codes, sequence[first:], prot)
+	}
+
+	switch (sequence.nuclSeqSize() - startPos) % 3 {
+	case 2:
+		if w.completeStop && sequence[len(sequence)-2] == tCode && sequence[len(sequence)-1] == aCode {
+			// incomplete 'TA' stop codon, completed as 'TAA'
+			prot = append(prot, stop)
+			break
+		}
+		switch w.trailingPartial {
+		case partialGuess:
 			// the last codon is only 2 nucleotide long, try to guess
 			// the corresponding AA
-			index := uint32(sequence[len(sequence)-2]) | uint32(sequence[len(sequence)-1])<<8
-			w.writeAA(w.codes[index])
-		case 1:
-			// the last codon is only 1 nucleotide long, no way to guess
-			// the corresponding AA
-			w.writeAA(unknown)
+			prot = append(prot, w.codes[codonIndex(sequence[len(sequence)-2], sequence[len(sequence)-1], nCode)])
+		case partialUnknown:
+			prot = append(prot, unknown)
+		}
+	case 1:
+		if w.completeStop && sequence[len(sequence)-1] == tCode {
+			// incomplete 'T' stop codon, completed as 'TAA'
+			prot = append(prot, stop)
+			break
+		}
+		// the last codon is only 1 nucleotide long, no way to guess
+		// the corresponding AA
+		if w.trailingPartial != partialDrop {
+			prot = append(prot, unknown)
 		}
-		w.trimAndReturn()
-		w.frameIndex++
 	}
+	return prot
 }
 
-// sequence id should look like
-// >sequenceID_<frame> comment
-func (w *writer) writeHeader(seqHeader []byte) {
-	end := bytes.IndexByte(seqHeader, ' ')
-	if end != -1 {
-		w.buf = append(w.buf, seqHeader[:end]...)
-		w.buf = append(w.buf, '_', suffixes[w.frameIndex])
-		w.buf = append(w.buf, seqHeader[end:]...)
-	} else {
-		w.buf = append(w.buf, seqHeader...)
-		w.buf = append(w.buf, '_', suffixes[w.frameIndex])
+// translateCircular appends the translation of a frame of a circular
+// sequence starting at startPos to prot. The translation continues after
+// the end of the sequence, from its start, until a stop codon is found,
+// so ORFs crossing the origin are translated continuously. It wraps
+// around the sequence at most once, and not at all if the last codon
+// before the origin is a stop codon or if the frame is back to its start
+// without any stop codon
+func (w *writer) translateCircular(sequence encodedSequence, startPos int, prot []byte) []byte {
+
+	seq := sequence[sequence.headerSize():]
+	n := len(seq)
+	hasStop := false
+	for pos := startPos; pos+3 <= 2*n; pos += 3 {
+		if pos >= startPos+n && !hasStop {
+			// back to the start of the frame without any stop codon,
+			// the whole sequence is already translated. If its length
+			// isn't a multiple of 3, the last codon overlaps the first
+			// one by at most 2 nucleotides
+			break
+		}
+		aa := w.codes[codonIndex(seq[pos%n], seq[(pos+1)%n], seq[(pos+2)%n])]
+		prot = append(prot, aa)
+		hasStop = hasStop || aa == stop
+		if pos+3 >= n && aa == stop {
+			// no ORF crosses the origin after this codon
+			break
+		}
+	}
+	return prot
+}
+
+// writeFrames writes the translated frames of a sequence that pass the
+// filter. If w.best is set, only the best frame according to this
+// criterion is written
+func (w *writer) writeFrames(seqHeader []byte) {
+
+	best := -1
+
+	for frameIndex, prot := range w.prots {
+
+		if w.framesToGenerate[frameIndex] == 0 {
+			continue
+		}
+		stats := newFrameStats(prot)
+		w.counts.stopCodons += int64(stats.internalStops)
+		if w.terminalStop[frameIndex] {
+			w.counts.stopCodons++
+		}
+		if !w.filter.keep(stats) {
+			continue
+		}
+		switch w.best {
+		case "":
+			w.writeFrame(seqHeader, frameIndex)
+			continue
+		case bestStretch:
+			stats.regionStart, stats.regionEnd = longestStretch(prot)
+		case bestORF:
+			stats.regionStart, stats.regionEnd = longestORF(prot)
+		}
+		if w.best != bestScore && stats.regionStart == stats.regionEnd {
+			// the frame has no stretch or ORF to select it by
+			continue
+		}
+		// frames are visited in order, so in case of tie the
+		// first frame is kept
+		if best == -1 || stats.betterThan(w.bestStats, w.best) {
+			best, w.bestStats = frameIndex, stats
+		}
 	}
-	w.newLine()
+
+	if best != -1 {
+		w.writeFrame(seqHeader, best)
+	}
+}
+
+// record is a region of a translated frame written as a single
+// fasta record
+type record struct {
+	frameIndex int
+	// range of the record in the translated frame
+	start, end int
 }
 
-func (w *writer) writeAA(aa byte) {
+func (w *writer) writeFrame(seqHeader []byte, frameIndex int) {
 
-	if w.currentLineLen == maxLineSize {
-		w.newLine()
+	if !w.split {
+		w.writeRecord(seqHeader, record{frameIndex: frameIndex, start: 0, end: len(w.prots[frameIndex])})
+		return
 	}
-	w.buf = append(w.buf, aa)
-	w.currentLineLen++
 
-	if w.trim {
-		if aa == stop || aa == unknown {
-			w.toTrim++
+	// write each stretch of the translation between two stop codons
+	// as a separate record
+	prot := w.prots[frameIndex]
+	start := 0
+	for start <= len(prot) {
+		end := bytes.IndexByte(prot[start:], stop)
+		if end == -1 {
+			end = len(prot)
 		} else {
-			w.toTrim = 0
+			end += start
+		}
+		first := start
+		if w.orf {
+			// an ORF starts with the first methionine of the fragment
+			m := bytes.IndexByte(prot[start:end], 'M')
+			if m == -1 {
+				first = end
+			} else {
+				first += m
+			}
+		}
+		if end-first > 0 && end-first >= w.minFragment {
+			w.writeRecord(seqHeader, record{frameIndex: frameIndex, start: first, end: end})
 		}
+		start = end + 1
 	}
 }
 
-func (w *writer) newLine() {
-	w.buf = append(w.buf, '\n')
-	w.currentLineLen = 0
+func (w *writer) writeRecord(seqHeader []byte, r record) {
+
+	w.writeHeader(seqHeader, r)
+	w.counts.recordsOut++
+	w.counts.frameRecords[r.frameIndex]++
 
-	if w.trim {
-		w.toTrim++
+	prot := w.prots[r.frameIndex][r.start:r.end]
+	// the last residue of the frame is not an internal stop codon
+	lastResidue := r.end == len(w.prots[r.frameIndex])
+	for len(prot) > 0 {
+
+		n := maxLineSize
+		if len(prot) < n {
+			n = len(prot)
+		}
+		start := len(w.buf)
+		w.buf = append(w.buf, prot[:n]...)
+		prot = prot[n:]
+
+		if w.stopChar != 0 {
+			// render internal stop codons
+			end := len(w.buf)
+			if len(prot) == 0 && lastResidue {
+				end--
+			}
+			for i := start; i < end; i++ {
+				if w.buf[i] == stop {
+					w.buf[i] = w.stopChar
+				}
+			}
+		}
+		w.buf = append(w.buf, '\n')
+
+		if len(w.buf) > w.out.bufferSize {
+			// stream large records instead of buffering them
+			if !w.partial {
+				w.out.Lock()
+			}
+			w.spans = append(w.spans, w.span(r.frameIndex))
+			w.partial = true
+			w.buf = w.out.writeLocked(w.buf, w.spans)
+			w.spans = w.spans[:0]
+		}
+	}
+
+	w.spans = append(w.spans, w.span(r.frameIndex))
+	if w.partial {
+		w.buf = w.out.writeLocked(w.buf, w.spans)
+		w.spans = w.spans[:0]
+		w.partial = false
+		w.out.Unlock()
 	}
 }
 
-func (w *writer) trimAndReturn() {
-	if w.toTrim > 0 {
-		w.buf = w.buf[:len(w.buf)-w.toTrim]
-		w.currentLineLen -= w.toTrim
+// span marks the end of the current record, or of the part of it
+// written so far, in w.buf
+func (w *writer) span(frameIndex int) recordSpan {
+	return recordSpan{
+		end:        len(w.buf),
+		frameIndex: frameIndex,
+		seqID:      w.seqID,
+		continued:  w.partial,
+	}
+}
+
+// sequence id should look like
+// >sequenceID_<frame> comment
+//
+// or, when splitting the translation at stop codons
+// >sequenceID_<frame>_<aaStart>-<aaEnd> nt=<from>-<to> comment
+//
+// with coordinates annotation, the strand and the offset of the frame are
+// added before the nucleotide range
+// >sequenceID_<frame> strand=<+|-> offset=<n> nt=<from>-<to> comment
+//
+// when selecting the best frame, the frame and the region used to select
+// it are added before the comment, for example
+// >sequenceID_<frame> frame=<frame> orf=<aaStart>-<aaEnd> comment
+//
+// when reporting terminal stop codons, 'stop=<yes|no>' is added before
+// the comment
+//
+// when merging several inputs, the input file of the sequence is added
+// before the comment as 'source=<file>'
+func (w *writer) writeHeader(seqHeader []byte, r record) {
+
+	end := bytes.IndexByte(seqHeader, ' ')
+	if end == -1 {
+		end = len(seqHeader)
+	}
+	w.buf = append(w.buf, seqHeader[:end]...)
+	w.buf = append(w.buf, '_')
+	w.buf = append(w.buf, w.frameNames[r.frameIndex]...)
+
+	if w.split {
+		w.buf = append(w.buf, '_')
+		w.buf = strconv.AppendInt(w.buf, int64(r.start+1), 10)
+		w.buf = append(w.buf, '-')
+		w.buf = strconv.AppendInt(w.buf, int64(r.end), 10)
+	}
+
+	if w.coords {
+		if r.frameIndex < 3 {
+			w.buf = append(w.buf, " strand=+"...)
+		} else {
+			w.buf = append(w.buf, " strand=-"...)
+		}
+		w.buf = append(w.buf, " offset="...)
+		w.buf = strconv.AppendInt(w.buf, int64(w.offsets[r.frameIndex]), 10)
+	}
+
+	if w.split || w.coords {
+		from, to := nuclRange(r.frameIndex, w.offsets[r.frameIndex], w.seqLen, r.start, r.end, w.seqCircular)
+		w.buf = append(w.buf, " nt="...)
+		w.buf = strconv.AppendInt(w.buf, int64(from), 10)
+		w.buf = append(w.buf, '-')
+		w.buf = strconv.AppendInt(w.buf, int64(to), 10)
 	}
 
-	if w.currentLineLen != 0 {
-		w.newLine()
+	if w.best != "" {
+		w.buf = append(w.buf, " frame="...)
+		w.buf = append(w.buf, w.frameNames[r.frameIndex]...)
+		if w.best != bestScore {
+			w.buf = append(w.buf, ' ')
+			w.buf = append(w.buf, w.best...)
+			w.buf = append(w.buf, '=')
+			w.buf = strconv.AppendInt(w.buf, int64(w.bestStats.regionStart+1), 10)
+			w.buf = append(w.buf, '-')
+			w.buf = strconv.AppendInt(w.buf, int64(w.bestStats.regionEnd), 10)
+		}
 	}
-	w.toTrim = 0
+	if w.reportStop {
+		if w.terminalStop[r.frameIndex] {
+			w.buf = append(w.buf, " stop=yes"...)
+		} else {
+			w.buf = append(w.buf, " stop=no"...)
+		}
+	}
+	if w.source != "" {
+		w.buf = append(w.buf, " source="...)
+		w.buf = append(w.buf, w.source...)
+	}
+	w.buf = append(w.buf, seqHeader[end:]...)
+	w.buf = append(w.buf, '\n')
 }
 
-func (w *writer) flush(out io.Writer, cancel context.CancelFunc, errs chan error) {
-	_, err := out.Write(w.buf)
+func (w *writer) flush() {
+	if len(w.buf) == 0 {
+		// the output may already be closed
+		return
+	}
+	w.buf = w.out.write(w.buf, w.spans)
+	w.spans = w.spans[:0]
+}
+
+// output serializes the writes of the workers to the output file, or to
+// the output files when a router is set. A worker keeps it locked while
+// it writes a large record in several parts, so records are never
+// interleaved
+type output struct {
+	sync.Mutex
+	w      io.Writer
+	router *router
+	// size above which a worker writes its buffer to the output
+	bufferSize int
+	// size above which the buffers of a worker are released after
+	// use instead of being reused, so a single large record doesn't
+	// keep memory allocated until the end of the run
+	retainSize int
+	cancel     context.CancelFunc
+	errs       chan error
+}
+
+// write writes buf to the output and returns it emptied. spans gives
+// the end of each record of buf, and is only used by the router
+func (o *output) write(buf []byte, spans []recordSpan) []byte {
+	o.Lock()
+	defer o.Unlock()
+	return o.writeLocked(buf, spans)
+}
+
+// writeLocked writes buf to the output and returns it emptied. On
+// failure, the error is sent to errs and the run is cancelled
+func (o *output) writeLocked(buf []byte, spans []recordSpan) []byte {
+	var err error
+	if o.router != nil {
+		err = o.router.write(buf, spans)
+	} else {
+		_, err = o.w.Write(buf)
+	}
 	if err != nil {
-		select {
-		case errs <- fmt.Errorf("fail to write to output file: %v", err):
-			cancel()
-		default:
-		}
+		o.fail(fmt.Errorf("fail to write to output file: %v", err))
+	}
+	return buf[:0]
+}
+
+// fail sends err to errs and cancels the run, unless another error has
+// already been reported
+func (o *output) fail(err error) {
+	select {
+	case o.errs <- err:
+		o.cancel()
+	default:
 	}
-	w.buf = w.buf[:0]
 }